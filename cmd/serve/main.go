@@ -1,25 +1,46 @@
 package main
 
 import (
+	"crypto/tls"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/fsnotify/fsnotify"
 	"zgo.at/zcert"
 )
 
 func main() {
 	// Flags; normally you'd get this from the CLI or env or whatnot.
 	var (
-		listen   = "localhost:9000"
 		certFile = ""
+		listen   = flag.String("listen", "localhost:9000", "Address to listen on")
+		hosts    = flag.String("hosts", "", "Comma-separated list of hostnames to create certificates for at startup")
+		minTLS   = flag.String("tls-min-version", "", "Minimum TLS version: 1.0, 1.1, 1.2, or 1.3")
+		watch    = flag.Bool("watch", false, "Reload the root certificate when it changes")
 	)
+	flag.Parse()
+
+	minVersion, ok := map[string]uint16{
+		"":    0,
+		"1.0": tls.VersionTLS10,
+		"1.1": tls.VersionTLS11,
+		"1.2": tls.VersionTLS12,
+		"1.3": tls.VersionTLS13,
+	}[*minTLS]
+	if !ok {
+		log.Fatalf("invalid -tls-min-version: %q", *minTLS)
+	}
 
 	// Use certificate from file if one was explicitly given.
-	if len(os.Args) > 1 {
-		certFile = os.Args[1]
+	if flag.NArg() > 0 {
+		certFile = flag.Arg(0)
 
 		// Install certificate; not done automatically as this may ask for the
 		// user password.
@@ -37,19 +58,39 @@ func main() {
 		}
 	}
 
-	serve := http.Server{Addr: listen}
+	serve := http.Server{Addr: *listen}
 	http.Handle("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintln(w, "Well, hello there!")
 	}))
 
 	// Create new root certificate if it doesn't exist yet, and use it to sign
 	// any host.
+	serve.TLSConfig = &tls.Config{MinVersion: minVersion}
 	if certFile == "" {
 		ca, created, err := zcert.New()
 		if err != nil {
 			log.Fatal(err)
 		}
-		serve.TLSConfig = ca.TLSConfig()
+		opts := zcert.TLSConfigOptions{MinVersion: minVersion}
+		serve.TLSConfig = ca.TLSConfigOpts(opts)
+		if *watch {
+			serve.TLSConfig = watchRoot(ca, opts)
+		}
+		if *hosts != "" {
+			// Create certificates now, rather than on the first request for
+			// every host.
+			var h []string
+			for _, s := range strings.Split(*hosts, ",") {
+				if s = strings.TrimSpace(s); s != "" {
+					h = append(h, s)
+				}
+			}
+			err := zcert.WarmCache(serve.TLSConfig, h...)
+			if err != nil {
+				log.Fatal(err)
+			}
+			log.Printf("created certificates for %s", strings.Join(h, ", "))
+		}
 		if created {
 			p, _ := ca.StorePath()
 			fmt.Println(strings.Repeat("=", 40))
@@ -59,9 +100,65 @@ func main() {
 		}
 	}
 
-	log.Printf("listening on %q with certificate from %q", listen, certFile)
+	log.Printf("listening on %q with certificate from %q", *listen, certFile)
 	err := serve.ListenAndServeTLS(certFile, certFile)
 	if err != nil {
 		log.Fatal(err)
 	}
 }
+
+// watchRoot returns a tls.Config which reloads the root certificate when it
+// changes on disk.
+func watchRoot(ca zcert.CARoot, opts zcert.TLSConfigOptions) *tls.Config {
+	var (
+		mu   sync.Mutex
+		tlsc = ca.TLSConfigOpts(opts)
+	)
+
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Fatal(err)
+	}
+	rootCert, rootKey := ca.StorePath()
+	err = w.Add(filepath.Dir(rootCert))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	go func() {
+		var reload <-chan time.Time
+		for {
+			select {
+			case err := <-w.Errors:
+				log.Print(err)
+			case ev := <-w.Events:
+				if ev.Name == rootCert || ev.Name == rootKey {
+					// The certificate and key are written separately; wait a
+					// bit so we don't load a mismatched pair.
+					reload = time.After(250 * time.Millisecond)
+				}
+			case <-reload:
+				err := ca.Reload()
+				if err != nil {
+					log.Printf("reloading root certificate: %s", err)
+					continue
+				}
+				mu.Lock()
+				tlsc = ca.TLSConfigOpts(opts)
+				mu.Unlock()
+				log.Printf("reloaded root certificate from %q", rootCert)
+			}
+		}
+	}()
+
+	return &tls.Config{
+		MinVersion: opts.MinVersion,
+		MaxVersion: opts.MaxVersion,
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			mu.Lock()
+			c := tlsc
+			mu.Unlock()
+			return c.GetCertificate(hello)
+		},
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"zgo.at/zcert"
+	"zgo.at/zli"
+)
+
+// batchSpec is the TOML file for the batch command:
+//
+//	[[cert]]
+//	hosts  = ["example.com", "*.example.com"]
+//	out    = "example.pem"
+//
+//	[[cert]]
+//	hosts  = ["me@example.com"]
+//	client = true
+//	key    = "rsa2048"
+//	valid  = "7d"
+type batchSpec struct {
+	Cert []batchCert `toml:"cert"`
+}
+
+type batchCert struct {
+	Hosts  []string `toml:"hosts"`  // Names to create the certificate for.
+	Out    string   `toml:"out"`    // Output file; defaults to the first host.
+	Client bool     `toml:"client"` // Create client certificate.
+	Key    string   `toml:"key"`    // Key algorithm; defaults to the -key flag.
+	Valid  string   `toml:"valid"`  // Validity period; defaults to the -valid flag.
+}
+
+// cmdBatch creates all certificates in the batch spec file.
+//
+// All certificates are attempted and errors are reported at the end, unless
+// failFast is set.
+func cmdBatch(root zcert.CARoot, flags makeFlags, dryRun, failFast bool, file string) {
+	var spec batchSpec
+	md, err := toml.DecodeFile(file, &spec)
+	zli.F(err)
+	if u := md.Undecoded(); len(u) > 0 {
+		zli.Fatalf("%s: unknown keys: %s", file, u)
+	}
+	if len(spec.Cert) == 0 {
+		zli.Fatalf("%s: no [[cert]] entries", file)
+	}
+
+	if !dryRun {
+		zli.F(flags.askPassword())
+	}
+
+	files := make([]string, 0, len(spec.Cert))
+	for i, c := range spec.Cert {
+		if len(c.Hosts) == 0 {
+			zli.Fatalf("%s: entry %d: must give at least one host", file, i+1)
+		}
+		if c.Key != "" {
+			_, err := zcert.ParseKeyAlgorithm(c.Key)
+			if err != nil {
+				zli.Fatalf("%s: entry %d: %s", file, i+1, err)
+			}
+		}
+		if _, err := parseValidity(c.Valid); err != nil {
+			zli.Fatalf("%s: entry %d: %s", file, i+1, err)
+		}
+		out := c.Out
+		if out == "" {
+			out = safePath(c.Hosts[0]) + flags.ext()
+		}
+		files = append(files, out)
+	}
+
+	var (
+		errs    = zcert.NewGroup(0)
+		m       manifest
+		created int
+	)
+	for i, c := range spec.Cert {
+		out := files[i]
+		if dryRun {
+			fmt.Printf("%s: %s", out, strings.Join(c.Hosts, ", "))
+			if c.Client {
+				fmt.Print(" (client)")
+			}
+			if c.Key != "" {
+				fmt.Printf(" (%s)", strings.ToLower(c.Key))
+			}
+			if c.Valid != "" {
+				fmt.Printf(" (valid %s)", c.Valid)
+			}
+			fmt.Println()
+			continue
+		}
+
+		if e := existing(flags.outFiles([]string{out})); !flags.force && e != "" {
+			errs.Append(fmt.Errorf("%s: already exists; use -f to overwrite", e))
+		} else {
+			f, r := flags, root
+			f.certOpts.Client = c.Client
+			if c.Key != "" {
+				r.KeyAlgorithm, _ = zcert.ParseKeyAlgorithm(c.Key)
+			}
+			if c.Valid != "" {
+				r.Validity, _ = parseValidity(c.Valid)
+			}
+			pemData, err := createCert(r, f, []string{out}, c.Hosts...)
+			if err != nil {
+				errs.Append(fmt.Errorf("%s: %w", out, err))
+			} else {
+				if flags.manifest != "" { // Only keep what's needed for the manifest.
+					m.add(flags.outFiles([]string{out}), pemData)
+				}
+				created++
+			}
+		}
+		if failFast && errs.Len() > 0 {
+			break
+		}
+	}
+	if dryRun {
+		return
+	}
+
+	if flags.manifest != "" && created > 0 {
+		m.write(flags.manifest, flags.owner)
+	}
+	fmt.Printf("%d of %d certificates created\n", created, len(spec.Cert))
+	if errs.Len() > 0 {
+		fmt.Fprint(os.Stderr, errs)
+		zli.Exit(1)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+
+	"zgo.at/zcert"
+	"zgo.at/zli"
+)
+
+// writeCACert writes the PEM-encoded root certificate to out, for use with
+// curl's --cacert and the like; "" or "-" means stdout.
+//
+// It verifies that a new certificate can be verified with just the written
+// certificates before writing anything.
+func writeCACert(root zcert.CARoot, out string) {
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.Certificate().Raw})
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		zli.Fatalf("writing CA certificate: can't parse PEM")
+	}
+	cert, err := root.MakeTLSCert(false, "localhost")
+	zli.F(err)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	zli.F(err)
+	_, err = leaf.Verify(x509.VerifyOptions{Roots: pool, DNSName: "localhost"})
+	if err != nil {
+		zli.Fatalf("writing CA certificate: new certificate doesn't verify: %s", err)
+	}
+
+	if out == "" || out == "-" {
+		_, err := os.Stdout.Write(data)
+		zli.F(err)
+		return
+	}
+	zli.F(writeFile(out, data, 0666, noOwner))
+	fmt.Fprintf(os.Stderr, "wrote %s; use with e.g. curl --cacert %s\n", out, shellQuote(out))
+}
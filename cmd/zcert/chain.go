@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"zgo.at/zcert"
+	"zgo.at/zli"
+)
+
+// verifyChain verifies a chain as a server would send it: the leaf certificate
+// first, followed by any intermediates.
+//
+// Unlike the verification in printInfo() this checks every link in the chain,
+// so it's easier to see where exactly a chain is broken.
+func verifyChain(root zcert.CARoot, file string) bool {
+	chain, err := readCerts(file)
+	zli.F(err)
+	if len(chain) == 0 {
+		zli.Fatalf("no certificates in %q", file)
+	}
+
+	fmt.Println(file)
+	var (
+		ok  = true
+		now = time.Now()
+	)
+	for i, c := range chain {
+		fmt.Printf("\t%d: %s\n", i, c.Subject)
+		fmt.Printf("\t   Serial:  %s\n", c.SerialNumber)
+		fmt.Printf("\t   Valid:   %s to %s\n", c.NotBefore.Format("2006-01-02 15:04:05"), c.NotAfter.Format("2006-01-02 15:04:05"))
+
+		switch {
+		case now.Before(c.NotBefore):
+			ok = false
+			fmt.Printf("\t   Error:   not yet valid\n")
+		case now.After(c.NotAfter):
+			ok = false
+			fmt.Printf("\t   Error:   expired\n")
+		}
+
+		if i == len(chain)-1 {
+			break
+		}
+		next := chain[i+1]
+		if !bytes.Equal(c.RawIssuer, next.RawSubject) {
+			ok = false
+			fmt.Printf("\t   Error:   issued by %q, but the next certificate is %q\n", c.Issuer, next.Subject)
+			continue
+		}
+		if !next.IsCA {
+			ok = false
+			fmt.Printf("\t   Error:   issuer %q is not a CA\n", next.Subject)
+			continue
+		}
+		if err := c.CheckSignatureFrom(next); err != nil {
+			ok = false
+			fmt.Printf("\t   Error:   %s\n", err)
+		}
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if root.Certificate() != nil {
+		pool.AddCert(root.Certificate())
+	}
+
+	// Check the last certificate separately, so we can report a missing
+	// intermediate rather than a generic "unknown authority" error.
+	last := chain[len(chain)-1]
+	if bytes.Equal(last.RawIssuer, last.RawSubject) {
+		fmt.Printf("\tNote:    chain includes the root certificate %q\n", last.Subject)
+	}
+	_, err = last.Verify(x509.VerifyOptions{
+		Roots:     pool,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		ok = false
+		fmt.Printf("\tError:   last certificate in chain is not signed by a trusted root (missing intermediate?): %s\n", err)
+	}
+
+	inter := x509.NewCertPool()
+	for _, c := range chain[1:] {
+		inter.AddCert(c)
+	}
+	_, err = chain[0].Verify(x509.VerifyOptions{
+		Roots:         pool,
+		Intermediates: inter,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		ok = false
+		fmt.Printf("\tVerify:  %s\n", err)
+	} else {
+		fmt.Printf("\tVerify:  OK\n")
+	}
+	return ok
+}
+
+// readCerts reads all CERTIFICATE PEM blocks from a file, skipping everything
+// else.
+func readCerts(file string) ([]*x509.Certificate, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var certs []*x509.Certificate
+	for {
+		var b *pem.Block
+		b, data = pem.Decode(data)
+		if b == nil {
+			break
+		}
+		if b.Type != "CERTIFICATE" {
+			continue
+		}
+
+		c, err := x509.ParseCertificate(b.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		certs = append(certs, c)
+	}
+	return certs, nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net"
+	"strings"
+
+	"zgo.at/zli"
+)
+
+// cmdCovers reports if the first certificate in file is valid for all hosts.
+func cmdCovers(file string, hosts []string) bool {
+	certs, err := readCerts(file)
+	zli.F(err)
+	if len(certs) == 0 {
+		zli.Fatalf("no certificates in %q", file)
+	}
+	c := certs[0]
+
+	ok := true
+	for _, h := range hosts {
+		err := c.VerifyHostname(h)
+		if err != nil {
+			ok = false
+			fmt.Printf("%s: doesn't cover %s: %s\n", file, h, err)
+			continue
+		}
+		fmt.Printf("%s: covers %s (matched %s)\n", file, h, matchedSAN(c, h))
+	}
+	return ok
+}
+
+// matchedSAN gets the SAN that matched host; this assumes that VerifyHostname()
+// already succeeded.
+func matchedSAN(c *x509.Certificate, host string) string {
+	if ip := net.ParseIP(strings.Trim(host, "[]")); ip != nil {
+		for _, cip := range c.IPAddresses {
+			if ip.Equal(cip) {
+				return "IP " + cip.String()
+			}
+		}
+		return "?"
+	}
+
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, n := range c.DNSNames {
+		if strings.ToLower(n) == host {
+			return "DNS name " + n
+		}
+	}
+	for _, n := range c.DNSNames {
+		if !strings.HasPrefix(n, "*.") {
+			continue
+		}
+		// Wildcards only match a single label.
+		if i := strings.IndexByte(host, '.'); i > 0 && strings.ToLower(n[1:]) == host[i:] {
+			return "wildcard " + n
+		}
+	}
+	return "?"
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"zgo.at/zcert"
+	"zgo.at/zcert/truststore"
+	"zgo.at/zli"
+)
+
+// cmdEnsure creates and installs the root if needed, and creates a certificate
+// for names if there isn't a valid one already.
+//
+// Every step is skipped if it's already done, so this can be run repeatedly.
+func cmdEnsure(root zcert.CARoot, flags makeFlags, names []string) {
+	if len(names) == 0 && flags.certOpts.Localhost {
+		names = []string{"localhost"}
+	}
+	if len(names) < 1 {
+		zli.Fatalf("must give at least one host")
+	}
+
+	changed := false
+	if !root.Exists() {
+		zli.F(root.Create())
+		fmt.Println("created root certificate")
+		changed = true
+	} else {
+		zli.F(root.Load())
+	}
+
+	if missing := missingStores(root); len(missing) > 0 {
+		// Only install to the stores that don't have it yet, as not all
+		// stores can install a root that's already there.
+		root.StoreOptions.Stores = missing
+		zli.F(root.Install())
+		fmt.Println("installed root certificate")
+		changed = true
+	}
+
+	flags.formats = []string{"pem"}
+	file := flags.out
+	if file == "" {
+		file = safePath(names[0]) + ".pem"
+	}
+	if file == "-" {
+		zli.Fatalf("can't write to stdout")
+	}
+
+	// Compare with the names as they'd end up in the certificate, as options
+	// such as -wildcard and IDN conversion change them.
+	tpl, err := root.CertTemplate(flags.certOpts, names...)
+	zli.F(err)
+	if !certCovers(root, file, certHosts(tpl)) {
+		writeCert(root, flags, []string{file}, names...)
+		fmt.Printf("created %s\n", file)
+		changed = true
+	}
+
+	if !changed {
+		fmt.Println("nothing to do")
+	}
+}
+
+// missingStores gets the names of all trust stores that don't have the root.
+func missingStores(root zcert.CARoot) []string {
+	var missing []string
+	for _, s := range truststore.FindOpts(root.StoreOptions) {
+		c, err := truststore.FindInstalled(s, root.Certificate())
+		switch {
+		case errors.Is(err, truststore.ErrNotSupported):
+			if !s.HasCert(root.Certificate()) {
+				missing = append(missing, s.Name())
+			}
+		case err != nil, c == nil, !bytes.Equal(c.Raw, root.Certificate().Raw):
+			missing = append(missing, s.Name())
+		}
+	}
+	return missing
+}
+
+// certCovers reports if file exists and has a currently valid certificate
+// signed by root for exactly the SANs in names.
+func certCovers(root zcert.CARoot, file string, names []string) bool {
+	if !Exists(file) {
+		return false
+	}
+	certs, err := readCerts(file)
+	if err != nil || len(certs) == 0 {
+		return false
+	}
+	c := certs[0]
+	if _, err := verifyRoot(root, c); err != nil {
+		return false
+	}
+
+	have := certHosts(c)
+	if len(have) != len(names) {
+		return false
+	}
+	for _, n := range names {
+		found := false
+		for _, h := range have {
+			if h == n {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
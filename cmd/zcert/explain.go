@@ -0,0 +1,155 @@
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"zgo.at/zcert"
+	"zgo.at/zli"
+)
+
+// cmdExplain prints the OpenSSL commands that roughly correspond to what "make"
+// would do. Nothing is executed.
+func cmdExplain(root zcert.CARoot, flags makeFlags, args []string) {
+	if len(args) < 1 || args[0] != "make" {
+		zli.Fatalf("can only explain make; use: explain make [flags] name [name ..]")
+	}
+	names := args[1:]
+	if len(names) == 0 && flags.certOpts.Localhost {
+		names = []string{"localhost"}
+	}
+	if len(names) < 1 {
+		zli.Fatalf("must give at least one host")
+	}
+
+	tpl, err := root.CertTemplate(flags.certOpts, names...)
+	zli.F(err)
+
+	out := flags.out
+	if out == "" || out == "-" {
+		out = safePath(names[0]) + ".pem"
+	}
+	var (
+		base              = strings.TrimSuffix(out, ".pem")
+		key, csr, ext     = base + "-key.pem", base + ".csr", base + ".ext"
+		rootCert, rootKey = root.StorePath()
+	)
+
+	switch root.KeyAlgorithm {
+	case zcert.RSA2048, zcert.RSA3072, zcert.RSA4096:
+		bits := strings.TrimPrefix(root.KeyAlgorithm.String(), "rsa")
+		fmt.Printf("# Generate a new %s-bit RSA private key.\n", bits)
+		fmt.Printf("openssl genpkey -algorithm RSA -pkeyopt rsa_keygen_bits:%s -out %s\n\n", bits, shellQuote(key))
+	case zcert.Ed25519:
+		fmt.Println("# Generate a new Ed25519 private key.")
+		fmt.Printf("openssl genpkey -algorithm ED25519 -out %s\n\n", shellQuote(key))
+	default:
+		curve := "P-" + strings.TrimPrefix(root.KeyAlgorithm.String(), "p")
+		fmt.Printf("# Generate a new ECDSA %s private key.\n", curve)
+		fmt.Printf("openssl genpkey -algorithm EC -pkeyopt ec_paramgen_curve:%s -out %s\n\n", curve, shellQuote(key))
+	}
+
+	fmt.Println("# Create a certificate signing request.")
+	fmt.Printf("openssl req -new -key %s -subj %s -out %s\n\n",
+		shellQuote(key), shellQuote(opensslSubject(tpl)), shellQuote(csr))
+
+	fmt.Println("# Write the extensions.")
+	fmt.Printf("cat > %s <<'EOF'\n%sEOF\n\n", shellQuote(ext), opensslExtensions(tpl, flags.certOpts.Comment))
+
+	fmt.Println("# Sign it with the root certificate.")
+	// Ed25519 doesn't use a separate digest.
+	digest := "-sha256 "
+	if root.Exists() && root.Load() == nil && root.Certificate().PublicKeyAlgorithm == x509.Ed25519 {
+		digest = ""
+	}
+	fmt.Printf("openssl x509 -req %s-in %s \\\n", digest, shellQuote(csr))
+	fmt.Printf("    -CA %s -CAkey %s \\\n", shellQuote(rootCert), shellQuote(rootKey))
+	fmt.Printf("    -set_serial 0x%x -days %d -extfile %s \\\n",
+		tpl.SerialNumber, int(math.Ceil(tpl.NotAfter.Sub(tpl.NotBefore).Hours()/24)), shellQuote(ext))
+	fmt.Printf("    -out %s.crt\n\n", shellQuote(base))
+
+	fmt.Println("# zcert writes the key and certificate to a single file.")
+	fmt.Printf("cat %s %s > %s\n", shellQuote(key), shellQuote(base+".crt"), shellQuote(out))
+	fmt.Printf("rm %s %s %s %s\n", shellQuote(csr), shellQuote(ext), shellQuote(key), shellQuote(base+".crt"))
+}
+
+func opensslSubject(tpl *x509.Certificate) string {
+	esc := strings.NewReplacer(`/`, `\/`, `=`, `\=`).Replace
+
+	var b strings.Builder
+	for _, o := range tpl.Subject.Organization {
+		b.WriteString("/O=" + esc(o))
+	}
+	for _, ou := range tpl.Subject.OrganizationalUnit {
+		b.WriteString("/OU=" + esc(ou))
+	}
+	if tpl.Subject.CommonName != "" {
+		b.WriteString("/CN=" + esc(tpl.Subject.CommonName))
+	}
+	return b.String()
+}
+
+func opensslExtensions(tpl *x509.Certificate, comment string) string {
+	var b strings.Builder
+	b.WriteString("basicConstraints = critical, CA:FALSE\n")
+
+	ku := []string{}
+	if tpl.KeyUsage&x509.KeyUsageDigitalSignature != 0 {
+		ku = append(ku, "digitalSignature")
+	}
+	if tpl.KeyUsage&x509.KeyUsageKeyEncipherment != 0 {
+		ku = append(ku, "keyEncipherment")
+	}
+	if len(ku) > 0 {
+		b.WriteString("keyUsage = critical, " + strings.Join(ku, ", ") + "\n")
+	}
+
+	eku := []string{}
+	for _, e := range tpl.ExtKeyUsage {
+		switch e {
+		case x509.ExtKeyUsageServerAuth:
+			eku = append(eku, "serverAuth")
+		case x509.ExtKeyUsageClientAuth:
+			eku = append(eku, "clientAuth")
+		case x509.ExtKeyUsageCodeSigning:
+			eku = append(eku, "codeSigning")
+		case x509.ExtKeyUsageEmailProtection:
+			eku = append(eku, "emailProtection")
+		}
+	}
+	if len(eku) > 0 {
+		b.WriteString("extendedKeyUsage = " + strings.Join(eku, ", ") + "\n")
+	}
+
+	san := []string{}
+	for _, n := range tpl.DNSNames {
+		san = append(san, "DNS:"+n)
+	}
+	for _, ip := range tpl.IPAddresses {
+		san = append(san, "IP:"+ip.String())
+	}
+	for _, e := range tpl.EmailAddresses {
+		san = append(san, "email:"+e)
+	}
+	for _, u := range tpl.URIs {
+		san = append(san, "URI:"+u.String())
+	}
+	if len(san) > 0 {
+		b.WriteString("subjectAltName = " + strings.Join(san, ", ") + "\n")
+	}
+	if comment != "" {
+		b.WriteString("nsComment = " + strconv.Quote(comment) + "\n")
+	}
+	return b.String()
+}
+
+// shellQuote quotes s for a POSIX shell, if needed.
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_.,/:@+=") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
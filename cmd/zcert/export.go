@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+
+	"howett.net/plist"
+	"zgo.at/zcert"
+	"zgo.at/zli"
+)
+
+// exportRoot writes the root certificate to out in the given format; "" or
+// "-" means stdout.
+func exportRoot(root zcert.CARoot, format, out string) {
+	var (
+		data []byte
+		err  error
+	)
+	switch format {
+	case "", "pem":
+		data = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.Certificate().Raw})
+	case "der":
+		data = root.Certificate().Raw
+	case "mobileconfig":
+		data, err = mobileconfig(root.Certificate())
+		zli.F(err)
+	default:
+		zli.Fatalf("unknown format for root export: %q; must be pem, der, or mobileconfig", format)
+	}
+
+	if out == "" || out == "-" {
+		_, err := os.Stdout.Write(data)
+		zli.F(err)
+		return
+	}
+	zli.F(writeFile(out, data, 0666, noOwner))
+	fmt.Fprintf(os.Stderr, "wrote %s\n", out)
+}
+
+// mobileconfig creates an unsigned Apple configuration profile with the
+// certificate as a root certificate payload, for deploying with MDM.
+//
+// The UUIDs are derived from the certificate, so exporting the same root
+// twice gives the same profile and MDM will update rather than duplicate it.
+func mobileconfig(cert *x509.Certificate) ([]byte, error) {
+	var (
+		name = cert.Subject.CommonName
+		id   = "at.zgo.zcert.root." + cert.SerialNumber.String()
+	)
+	if name == "" {
+		name = "zcert development CA"
+	}
+
+	profile := map[string]interface{}{
+		"PayloadType":        "Configuration",
+		"PayloadVersion":     1,
+		"PayloadIdentifier":  id,
+		"PayloadUUID":        certUUID(cert, "profile"),
+		"PayloadDisplayName": name,
+		"PayloadDescription": "Trust the zcert development root certificate " + name + ".",
+		"PayloadContent": []map[string]interface{}{{
+			"PayloadType":                "com.apple.security.root",
+			"PayloadVersion":             1,
+			"PayloadIdentifier":          id + ".cert",
+			"PayloadUUID":                certUUID(cert, "cert"),
+			"PayloadDisplayName":         name,
+			"PayloadCertificateFileName": "zcert-root.cer",
+			"PayloadContent":             cert.Raw,
+		}},
+	}
+	return plist.MarshalIndent(profile, plist.XMLFormat, "\t")
+}
+
+// certUUID derives a stable name-based UUID from the certificate and salt.
+func certUUID(cert *x509.Certificate, salt string) string {
+	h := sha256.Sum256(append([]byte(salt+":"), cert.Raw...))
+	h[6] = (h[6] & 0x0f) | 0x50 // Version 5-style name-based UUID.
+	h[8] = (h[8] & 0x3f) | 0x80 // RFC 4122 variant.
+	return fmt.Sprintf("%X-%X-%X-%X-%X", h[0:4], h[4:6], h[6:8], h[8:10], h[10:16])
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"zgo.at/zcert"
+	"zgo.at/zli"
+)
+
+// certInfo is the information that info prints about a certificate.
+type certInfo struct {
+	File               string     `json:"file"`
+	Subject            string     `json:"subject"`
+	NotBefore          time.Time  `json:"not_before"`
+	NotAfter           time.Time  `json:"not_after"`
+	DaysLeft           int        `json:"days_left"`
+	Serial             string     `json:"serial"`
+	SignatureAlgorithm string     `json:"signature_algorithm"`
+	Key                keyInfo    `json:"key"`
+	DNSNames           []string   `json:"dns_names"`
+	IPs                []string   `json:"ips"`
+	Emails             []string   `json:"emails"`
+	URIs               []string   `json:"uris"`
+	ClientCert         bool       `json:"client_cert"`
+	Verify             verifyInfo `json:"verify"`
+}
+
+// verifyInfo is the result of verifying a certificate.
+type verifyInfo struct {
+	OK     bool        `json:"ok"`
+	Error  string      `json:"error,omitempty"`
+	Chains []chainInfo `json:"chains,omitempty"`
+}
+
+// chainInfo is the issuer of a verified chain.
+type chainInfo struct {
+	Serial  string `json:"serial"`
+	Subject string `json:"subject"`
+}
+
+// newCertInfo gets information about the first certificate in file, and
+// verifies it against the root, or the system store if that fails.
+func newCertInfo(root zcert.CARoot, file string) (certInfo, error) {
+	cert, err := tls.LoadX509KeyPair(file, file)
+	if err != nil {
+		return certInfo{}, err
+	}
+	if len(cert.Certificate) == 0 {
+		return certInfo{}, fmt.Errorf("no certificates in %q", file)
+	}
+	c, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return certInfo{}, err
+	}
+
+	info := certInfo{
+		File:               file,
+		Subject:            c.Subject.String(),
+		NotBefore:          c.NotBefore.UTC(),
+		NotAfter:           c.NotAfter.UTC(),
+		DaysLeft:           daysLeft(c),
+		Serial:             c.SerialNumber.String(),
+		SignatureAlgorithm: c.SignatureAlgorithm.String(),
+		Key:                newKeyInfo(c.PublicKey),
+		DNSNames:           append([]string{}, c.DNSNames...),
+		IPs:                make([]string, 0, len(c.IPAddresses)),
+		Emails:             append([]string{}, c.EmailAddresses...),
+		URIs:               make([]string, 0, len(c.URIs)),
+		ClientCert:         isClientCert(c),
+	}
+	for _, ip := range c.IPAddresses {
+		info.IPs = append(info.IPs, ip.String())
+	}
+	for _, u := range c.URIs {
+		info.URIs = append(info.URIs, u.String())
+	}
+
+	chains, err := verifyRoot(root, c)
+	if err != nil {
+		// Won't fall back to the system store automatically.
+		pool := x509.NewCertPool()
+		if len(cert.Certificate) > 1 {
+			for _, x := range cert.Certificate[1:] {
+				ic, err := x509.ParseCertificate(x)
+				if err != nil {
+					return certInfo{}, err
+				}
+				pool.AddCert(ic)
+			}
+		}
+		chains, err = c.Verify(x509.VerifyOptions{Intermediates: pool})
+	}
+	if err != nil {
+		info.Verify.Error = err.Error()
+	} else {
+		info.Verify.OK = true
+	}
+	for _, chain := range chains {
+		issuer := chain[0]
+		if len(chain) > 1 {
+			issuer = chain[1]
+		}
+		info.Verify.Chains = append(info.Verify.Chains, chainInfo{
+			Serial:  issuer.SerialNumber.String(),
+			Subject: issuer.Subject.String(),
+		})
+	}
+	return info, nil
+}
+
+// cmdInfo prints information about all files.
+//
+// If expiring is 0 or more it reports false if any of the certificates expire
+// within that many days.
+func cmdInfo(root zcert.CARoot, files []string, asJSON bool, expiring int) bool {
+	var (
+		infos = make([]certInfo, 0, len(files))
+		ok    = true
+	)
+	for _, file := range files {
+		info, err := newCertInfo(root, file)
+		zli.F(err)
+		infos = append(infos, info)
+
+		if expiring >= 0 && info.NotAfter.Before(time.Now().Add(time.Duration(expiring)*24*time.Hour)) {
+			fmt.Fprintf(os.Stderr, "%s: expires within %d days (%d days left)\n", file, expiring, info.DaysLeft)
+			ok = false
+		}
+	}
+
+	if asJSON {
+		var v interface{} = infos
+		if len(infos) == 1 {
+			v = infos[0]
+		}
+		j, err := json.MarshalIndent(v, "", "\t")
+		zli.F(err)
+		fmt.Println(string(j))
+		return ok
+	}
+
+	for i, info := range infos {
+		printInfo(info)
+		if i < len(infos)-1 {
+			fmt.Println("")
+		}
+	}
+	return ok
+}
+
+func printInfo(info certInfo) {
+	fmt.Println(info.File)
+	fmt.Printf("\tSubject:    %s\n", info.Subject)
+	fmt.Printf("\tValid:      %s to %s\n", info.NotBefore.Format("2006-01-02 15:04:05"), info.NotAfter.Format("2006-01-02 15:04:05"))
+	fmt.Printf("\tDays left:  %d\n", info.DaysLeft)
+	fmt.Printf("\tSerial:     %s\n", info.Serial)
+	fmt.Printf("\tAlgorithm:  %s\n", info.SignatureAlgorithm)
+	fmt.Printf("\tDNSNames:   %s\n", info.DNSNames)
+	fmt.Printf("\tIPs:        %s\n", info.IPs)
+	fmt.Printf("\tEmails:     %s\n", info.Emails)
+	fmt.Printf("\tURIs:       %s\n", info.URIs)
+	if info.ClientCert {
+		fmt.Println("\tClientCert: true")
+	}
+
+	if !info.Verify.OK {
+		fmt.Printf("\tVerify:     %s\n", info.Verify.Error)
+		return
+	}
+	pad := strings.Repeat(" ", 12)
+	fmt.Print("\tVerify:     ")
+	for i, chain := range info.Verify.Chains {
+		if i > 0 {
+			fmt.Print("\t" + pad)
+		}
+		fmt.Printf("Serial:  %s\n", chain.Serial)
+		fmt.Printf("\t%sSubject: %s\n", pad, chain.Subject)
+	}
+}
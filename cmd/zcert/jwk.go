@@ -0,0 +1,100 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+)
+
+// jwk is a JSON Web Key (RFC 7517), with the certificate chain in x5c.
+type jwk struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv,omitempty"`
+
+	// EC and OKP
+	X string `json:"x,omitempty"`
+	Y string `json:"y,omitempty"`
+
+	// RSA
+	N  string `json:"n,omitempty"`
+	E  string `json:"e,omitempty"`
+	P  string `json:"p,omitempty"`
+	Q  string `json:"q,omitempty"`
+	DP string `json:"dp,omitempty"`
+	DQ string `json:"dq,omitempty"`
+	QI string `json:"qi,omitempty"`
+
+	D   string   `json:"d,omitempty"`
+	X5C []string `json:"x5c,omitempty"`
+}
+
+// pemToJWK converts the PEM-encoded key and certificate to a JWK.
+func pemToJWK(pemData []byte) ([]byte, error) {
+	cert, err := tls.X509KeyPair(pemData, pemData)
+	if err != nil {
+		return nil, err
+	}
+
+	k, err := newJWK(cert.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range cert.Certificate {
+		k.X5C = append(k.X5C, base64.StdEncoding.EncodeToString(c))
+	}
+
+	j, err := json.MarshalIndent(k, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	return append(j, '\n'), nil
+}
+
+func newJWK(key crypto.PrivateKey) (jwk, error) {
+	b64 := base64.RawURLEncoding.EncodeToString
+	// Fixed-size encoding, as required for the EC coordinates.
+	fixed := func(n *big.Int, size int) string {
+		b := n.Bytes()
+		return b64(append(make([]byte, size-len(b)), b...))
+	}
+
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		return jwk{
+			Kty: "EC",
+			Crv: k.Curve.Params().Name,
+			X:   fixed(k.X, size),
+			Y:   fixed(k.Y, size),
+			D:   fixed(k.D, size),
+		}, nil
+	case *rsa.PrivateKey:
+		k.Precompute()
+		return jwk{
+			Kty: "RSA",
+			N:   b64(k.N.Bytes()),
+			E:   b64(big.NewInt(int64(k.E)).Bytes()),
+			D:   b64(k.D.Bytes()),
+			P:   b64(k.Primes[0].Bytes()),
+			Q:   b64(k.Primes[1].Bytes()),
+			DP:  b64(k.Precomputed.Dp.Bytes()),
+			DQ:  b64(k.Precomputed.Dq.Bytes()),
+			QI:  b64(k.Precomputed.Qinv.Bytes()),
+		}, nil
+	case ed25519.PrivateKey:
+		return jwk{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   b64(k.Public().(ed25519.PublicKey)),
+			D:   b64(k.Seed()),
+		}, nil
+	default:
+		return jwk{}, fmt.Errorf("jwk: unsupported key type %T", key)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"zgo.at/zcert"
+	"zgo.at/zli"
+)
+
+type listEntry struct {
+	path   string
+	cert   *x509.Certificate
+	byRoot bool // Signed by the zcert root.
+}
+
+// findCerts calls fn for all certificates in dir, recursively. Only the first
+// certificate in every file is used.
+//
+// Certificates are passed to fn as they're found rather than collected, so
+// this works for directories with any number of certificates.
+func findCerts(root zcert.CARoot, dir string, fn func(listEntry) error) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".pem", ".crt", ".cer":
+		default:
+			return nil
+		}
+
+		certs, err := readCerts(path)
+		if err != nil || len(certs) == 0 {
+			return nil // Not a certificate we can read; just skip it.
+		}
+		c := certs[0]
+		return fn(listEntry{
+			path: path,
+			cert: c,
+			// Only check the signature rather than using verifyRoot(), as
+			// that would also fail for expired certificates.
+			byRoot: root.Certificate() != nil && c.CheckSignatureFrom(root.Certificate()) == nil,
+		})
+	})
+}
+
+// cmdList prints all certificates in dir.
+func cmdList(root zcert.CARoot, dir string, asCSV bool) {
+	if asCSV {
+		w := csv.NewWriter(os.Stdout)
+		zli.F(w.Write([]string{"path", "cn", "sans", "serial", "not_before", "not_after", "days_left", "zcert_root"}))
+		zli.F(findCerts(root, dir, func(e listEntry) error {
+			err := w.Write([]string{
+				e.path,
+				e.cert.Subject.CommonName,
+				strings.Join(certHosts(e.cert), ", "),
+				e.cert.SerialNumber.String(),
+				e.cert.NotBefore.UTC().Format(time.RFC3339),
+				e.cert.NotAfter.UTC().Format(time.RFC3339),
+				strconv.Itoa(daysLeft(e.cert)),
+				strconv.FormatBool(e.byRoot),
+			})
+			w.Flush()
+			if err != nil {
+				return err
+			}
+			return w.Error()
+		}))
+		return
+	}
+
+	// The table is sorted by expiry, so the certificates that need attention
+	// are at the top; CSV is printed as we go so it works for any number of
+	// certificates.
+	var list []listEntry
+	zli.F(findCerts(root, dir, func(e listEntry) error {
+		list = append(list, e)
+		return nil
+	}))
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].cert.NotAfter.Before(list[j].cert.NotAfter)
+	})
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "Path\tNames\tSerial\tExpires\tDays left\tzcert root")
+	for _, e := range list {
+		byRoot := "no"
+		if e.byRoot {
+			byRoot = "yes"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\n", e.path, strings.Join(certHosts(e.cert), ", "),
+			shortSerial(e.cert), e.cert.NotAfter.Format("2006-01-02"), daysLeft(e.cert), byRoot)
+	}
+	zli.F(w.Flush())
+}
+
+// shortSerial gets the serial as hex, shortened to fit in a table; the full
+// serial is in the CSV output.
+func shortSerial(c *x509.Certificate) string {
+	s := c.SerialNumber.Text(16)
+	if len(s) > 12 {
+		return s[:12] + "…"
+	}
+	return s
+}
+
+// daysLeft gets the number of days until the certificate expires; this is
+// negative for expired certificates.
+func daysLeft(c *x509.Certificate) int {
+	return int(time.Until(c.NotAfter).Hours() / 24)
+}
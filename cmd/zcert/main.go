@@ -1,15 +1,21 @@
 package main
 
 import (
-	"crypto/tls"
+	"bytes"
+	"context"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"zgo.at/zcert"
+	"zgo.at/zcert/truststore"
 	"zgo.at/zli"
 )
 
@@ -20,28 +26,324 @@ Commands:
 
   info   Print information about a certificate.
 
+            -json            Print as JSON; this is an array if more than
+                             one file is given.
+            -expiring N      Exit with 2 if any of the certificates expire
+                             within N days; for use in monitoring. Errors
+                             exit with 1.
+            file [file ..]   Certificates to show.
+
+  selftest  Create a certificate for localhost, serve it over HTTPS, and
+            connect to it to check that everything works. This uses a
+            temporary root if there is no root yet.
+
+            -ephemeral       Always use a temporary root.
+
+  verify Verify a certificate against the zcert root, or the system's root
+         certificates if that fails. Exits with 1 if it doesn't verify, or
+         doesn't cover the host.
+
+            file             Certificate to verify; any other certificates in
+                             the file are used as intermediates.
+            host             Also check that the certificate covers this
+                             hostname or IP address.
+
+  verify-chain  Verify that a PEM bundle with the leaf certificate followed by
+                any intermediates (as a server would send it) chains to the
+                root or system certificates, and report where it breaks.
+
+  list   List all certificates in a directory (recursively), with the names,
+         serial, expiry, and if they were signed by the zcert root. Sorted by
+         expiry, so certificates that expire soonest are listed first.
+
+            -csv             Print as CSV, with the path, CommonName, SANs,
+                             serial, validity, days left, and if it was
+                             signed by the zcert root. This isn't sorted,
+                             but printed in the order files are found, so
+                             it works for any number of certificates.
+            dir              Directory to look in; default is the current
+                             directory.
+
+  match  Check if a private key belongs to a certificate.
+
+            cert-file        Certificate to check.
+            key-file         Private key to check; defaults to cert-file, as
+                             zcert writes both to the same file.
+
   make   Create a new certificate signed with the root certificate.
 
             -out filename    Set output file; use - for stdout, default is to use host
+            -hosts-file file Read names from this file, one per line, in
+                             addition to the names on the commandline; use -
+                             for stdin. Lines starting with # are ignored.
+            -duplicate-to    Comma-separated list of extra files to write the
+                             same certificate to.
             -client          Create client certificate.
+            -split           Create a separate certificate for every name,
+                             instead of one certificate for all of them.
+            -wildcard        Also add a wildcard for every domain, so that
+                             example.com also covers *.example.com.
+            -require-san     Skip names that can't be added as a SAN (e.g.
+                             invalid hostnames), and only error out if none
+                             of them can. Without this any invalid name is
+                             an error.
+            -wildcard-depth N
+                             Also add multi-level wildcards up to this depth
+                             for every wildcard; e.g. with 2 '*.example.com'
+                             also adds '*.*.example.com'. Only some clients
+                             accept these; browsers don't.
+            -all-ips         Add the IP addresses of all local network
+                             interfaces.
+            -loopback        Also add loopback addresses with -all-ips.
+            -localhost       Add localhost, 127.0.0.1, and ::1; no names
+                             need to be given with this.
+            -chown user:group
+                             Set the owner of the written files; the default
+                             is the invoking user when run with sudo.
+            -print-path      Print the paths of the written files to stdout,
+                             one per line.
+            -format pem|jwk|p12|der
+                             Output format; pem is the default, jwk writes
+                             the private key as a JSON Web Key with the
+                             certificate in "x5c", and p12 writes a PKCS#12
+                             (.pfx) file with the key, certificate, and root
+                             certificate. der writes the DER certificate to
+                             example.com.der and the PKCS#8 DER key to
+                             example.com-key.der.
+                             Use a comma-separated list to write the same
+                             key and certificate in more than one format;
+                             the extension of -out is replaced for every
+                             format.
+            -valid period    How long the certificate is valid for, as a
+                             number of days ("90d"), years ("2y"), or a
+                             duration ("12h"); default is one year. It's
+                             never longer than the root certificate. Some
+                             clients (such as Safari) reject server
+                             certificates valid for longer than 398 days.
+            -longer-than-root
+                             Allow -valid to be longer than the root
+                             certificate, instead of using the root's expiry.
+                             Useful for testing how clients deal with this.
+            -p12             Shortcut for -format p12, or add p12 to -format.
+            -der             Shortcut for -format der, or add der to -format.
+            -password pw     Password for PKCS#12 files; it's read from stdin
+                             if this isn't given. Can be empty.
+            -separate        Write the private key to a separate file instead
+                             of adding it to the certificate file; for
+                             example.com.pem the key is written to
+                             example.com-key.pem.
+            -eku list        Comma-separated list of extended key usages,
+                             instead of the defaults: server, client,
+                             code-signing, email, timestamp, ocsp, or the
+                             RFC 5280 names such as serverAuth.
+            -key-usage list  Comma-separated list of key usages, instead of
+                             the default digitalSignature,keyEncipherment:
+                             digitalSignature, contentCommitment,
+                             keyEncipherment, dataEncipherment, keyAgreement,
+                             encipherOnly, decipherOnly.
+            -comment text    Add a Netscape Comment extension, which some
+                             certificate viewers show to identify the
+                             certificate. Must be ASCII, as it's stored as
+                             an IA5String.
+            -chain           Add the root certificate after the certificate,
+                             for clients that don't have the root installed.
+            -intermediate    Sign with a new intermediate CA, which is added
+                             to the certificate file after the certificate.
+                             The root must be created with -max-path-len 1 or
+                             higher.
+            -dummy-sct N     Add N dummy Certificate Transparency SCTs; these
+                             are well-formed but never verify, and are only
+                             useful for testing clients that require SCTs.
+            -ocsp-url list   Comma-separated list of OCSP responder URLs to
+                             add to the certificate.
+            -manifest file   Write a JSON file describing the created
+                             certificates: files, serial, fingerprint, SANs,
+                             validity, and key type. Use - for stdout.
             name [name ..]   Domains, IPs, or emails to create certificate for.
 
+  sign   Sign a certificate signing request (CSR) with the root certificate.
+         Only the CSR's CommonName is used as the hostname, unless
+         -copy-extensions is given.
+
+            -out filename    Write to this file; default is stdout.
+            -copy-extensions Use the SANs and extended key usages requested in
+                             the CSR, instead of the defaults.
+            -allow-eku list  Comma-separated list of extended key usages that
+                             may be copied: server, client, code-signing,
+                             email, timestamp, ocsp. Signing fails if the CSR
+                             requests anything else. Default is server,client.
+            file             CSR to sign, as PEM or DER.
+
+  ensure Create and install the root certificate if needed, and create a
+         certificate for the names unless a valid one already exists. Every
+         step is skipped if it's already done, so it's safe to run
+         repeatedly. Only PEM is written.
+
+            -out filename    Certificate to check and write; default is
+                             the first name with .pem appended.
+            name [name ..]   Domains, IPs, or emails.
+
+  renew  Create a new certificate for the same names as an existing one, and
+         replace it. It's a client certificate if the existing one is, and
+         uses the same key algorithm unless -key is given.
+
+            -out filename    Write to this file instead of replacing it.
+            -keep-serial     Keep the serial number; serial numbers should be
+                             unique and clients may reject certificates with
+                             a serial number they've seen before, so this is
+                             intended for stable test fixtures only.
+            file             Certificate to renew.
+
+  batch  Create all certificates listed in a TOML file; for example:
+
+             [[cert]]
+             hosts  = ["example.com", "*.example.com"]
+             out    = "example.pem"    # Default is to use the first host.
+
+             [[cert]]
+             hosts  = ["me@example.com"]
+             client = true
+             key    = "rsa2048"        # Default is the -key flag.
+             valid  = "7d"             # Default is the -valid flag.
+
+         All certificates are created even if some fail, and errors are
+         reported at the end.
+
+            -dry-run         Only print what would be created.
+            -fail-fast       Stop on the first error.
+            file             TOML file to read.
+
+  revoke Add certificates to the list of revoked certificates, which is
+         stored next to the root certificate. Use root crl to get a CRL.
+
+            file [file ..]   Certificates to revoke.
+
+  covers Check if a certificate is valid for a hostname or IP address, and
+         which name in the certificate matched it.
+
+            file             Certificate to check.
+            host [host ..]   Hostnames or IP addresses to check.
+
+  explain make [flags] name [name ..]
+         Print the OpenSSL commands that roughly correspond to what make would
+         do with the same flags and names, without running anything.
+
   root   Manage root certificate.
 
-           info             Show info.
+           info             Show info, including which trust stores have the
+                            root installed; use -json for JSON output.
            install          Install a root certificate to all supported trust
                             stores; create a new one if it doesn't exist yet.
            uninstall        Uninstall root certificate from trust stores.
            create           Create a new certificate. Use -force of -f to
                             override any existing root certificate.
            remove           Remove the root certificate
+           import cert key  Use an existing CA certificate and key as the
+                            root certificate. The certificate must be a CA
+                            with the certSign key usage. Use -force or -f to
+                            override any existing root certificate.
+           cacert           Write the root certificate for use with HTTP
+                            clients, e.g. curl's --cacert or wget's
+                            --ca-certificate. Use -out to write to a file
+                            instead of stdout.
+           export           Export the root certificate; use -format to set
+                            the format, and -out to write to a file instead
+                            of stdout. Formats are pem (default), der, and
+                            mobileconfig, for an unsigned Apple configuration
+                            profile to deploy with MDM.
+           crl              Write a CRL with all certificates revoked with
+                            the revoke command, valid for 7 days. Use
+                            -format pem (default) or der, and -out to write
+                            to a file instead of stdout.
+           name             Print the name (alias) the root certificate is
+                            installed as in the NSS, Java, and Unix trust
+                            stores, to find it in certificate managers.
+                            macOS and Windows show the CommonName instead.
+           status           Show if the root certificate is installed in
+                            every trust store, and if the installed
+                            certificate is the same as the one on disk.
+
+           -max-errors N    Report at most N errors from install or
+                            uninstall; useful with many Firefox profiles.
+           -compat          Create an RSA root certificate instead of ECDSA,
+                            for trust stores that don't handle ECDSA roots
+                            well (some older Java and Android versions).
+                            Certificates still use ECDSA.
+           -max-path-len N  Allow this many intermediate CAs below a new root
+                            certificate; default is 0, use -1 for no limit.
+           -single-file     Store a new root certificate and its key in a
+                            single rootCA.pem file, instead of the key in
+                            rootCA-key.pem.
+           -key-id method   Method to derive the SubjectKeyId of a new root:
+                            sha1 (default), or sha256, sha384, sha512 for the
+                            RFC 7093 methods.
+           -retries N       Retry this many times if the NSS database is
+                            locked, e.g. because a browser is using it;
+                            default is 3, use -1 to disable.
+           -retry-wait d    Wait this long between retries, multiplied by the
+                            attempt number; default is "1s".
+           -no-firefox-profiles
+                            Only use the shared NSS databases such as
+                            ~/.pki/nssdb (used by Chrome on Linux), and not
+                            Firefox profiles.
+           -no-shared-nssdb Only use Firefox profiles, and not the shared NSS
+                            databases.
+           -store list      Comma-separated list of trust stores to install
+                            to or uninstall from, instead of all of them;
+                            this overrides TRUST_STORES. Names are nss,
+                            java, unix, darwin, windows, android (an emulator
+                            or device connected with adb), and system for
+                            the operating system's store.
+           -java-keystore file
+                            Use this Java keystore (JKS or PKCS#12) instead
+                            of the JDK's cacerts, e.g. an application's
+                            bundled truststore. It's created if it doesn't
+                            exist.
+           -java-storepass pw
+                            Password for the Java keystore; default is
+                            "changeit".
+           -store-timeout d Skip a trust store if installing or uninstalling
+                            takes longer than this, e.g. "30s" or "1m".
+           -valid period    How long a new root certificate is valid for, in
+                            the same format as make -valid; default is 10
+                            years.
+           -user            Also install to (or uninstall from) the current
+                            user's trust store, where supported. Currently
+                            this is just the macOS login keychain.
+
+Key flags for make and root create:
+  -key alg          Key algorithm: p256 (ECDSA, the default), p384, rsa2048,
+                    rsa3072, rsa4096, or ed25519. Some older appliances, load
+                    balancers, and Java 8 don't handle ECDSA certificates
+                    well, and most browsers don't support Ed25519.
+
+Subject flags for make and root create; these override the defaults:
+  -cn name          CommonName.
+  -org name         Organization; can be given more than once.
+  -org-unit name    OrganizationalUnit; can be given more than once.
+  -country name     Country; can be given more than once.
+  -province name    Province; can be given more than once.
+  -locality name    Locality; can be given more than once.
 
 Global flags:
-  -v -verbose   Print verbose information to stderr.
+  -v -verbose       Print verbose information to stderr.
+  -caroot dir       Directory to store the root certificate; this overrides
+                    CAROOT.
+  -cache-fallback   Store the root certificate in the cache directory if the
+                    default location isn't writable; it may not persist across
+                    reboots.
+  -quiet-errors     Don't print progress or the output of commands that failed;
+                    only print the final error.
 
 Environment:
     CAROOT    Directory to store the root certificate. If this isn't set it's
               stored in the local user's profile directory.
+    TRUST_STORES
+              Comma-separated list of trust stores to install to and uninstall
+              from, instead of all of them; same as root -store.
+    SOURCE_DATE_EPOCH
+              Use this Unix timestamp as the current time for the validity of
+              new certificates, for reproducible output.
 `
 
 const usageDetail = `
@@ -69,17 +371,223 @@ Example:
 func main() {
 	f := zli.NewFlags(os.Args)
 	var (
-		verbose = f.Bool(false, "verbose", "v")
-		client  = f.Bool(false, "client", "c")
-		out     = f.String("", "out", "o")
-		force   = f.Bool(false, "force", "f")
+		verbose       = f.Bool(false, "verbose", "v")
+		cacheFallback = f.Bool(false, "cache-fallback")
+		caroot        = f.String("", "caroot")
+		quietErrors   = f.Bool(false, "quiet-errors")
+		client        = f.Bool(false, "client", "c")
+		out           = f.String("", "out", "o")
+		force         = f.Bool(false, "force", "f")
+
+		duplicateTo  = f.String("", "duplicate-to")
+		split        = f.Bool(false, "split")
+		allIPs       = f.Bool(false, "all-ips")
+		loopback     = f.Bool(false, "loopback")
+		localhost    = f.Bool(false, "localhost")
+		keepSerial   = f.Bool(false, "keep-serial")
+		user         = f.Bool(false, "user")
+		maxErrors    = f.Int(0, "max-errors")
+		manifest     = f.String("", "manifest")
+		chown        = f.String("", "chown")
+		dryRun       = f.Bool(false, "dry-run")
+		failFast     = f.Bool(false, "fail-fast")
+		asCSV        = f.Bool(false, "csv")
+		asJSON       = f.Bool(false, "json")
+		expiring     = f.Int(0, "expiring")
+		requireSAN   = f.Bool(false, "require-san")
+		keyID        = f.String("", "key-id")
+		keyAlg       = f.String("", "key")
+		valid        = f.String("", "valid")
+		longerRoot   = f.Bool(false, "longer-than-root")
+		ocspURL      = f.String("", "ocsp-url")
+		comment      = f.String("", "comment")
+		ekuFlag      = f.String("", "eku")
+		kuFlag       = f.String("", "key-usage")
+		separate     = f.Bool(false, "separate")
+		dummySCT     = f.Int(0, "dummy-sct")
+		p12          = f.Bool(false, "p12")
+		der          = f.Bool(false, "der")
+		password     = f.String("", "password")
+		storeTimeout = f.String("", "store-timeout")
+		compat       = f.Bool(false, "compat")
+		printPath    = f.Bool(false, "print-path")
+		retries      = f.Int(0, "retries")
+		retryWait    = f.String("", "retry-wait")
+		wcDepth      = f.Int(0, "wildcard-depth")
+		ephemeral    = f.Bool(false, "ephemeral")
+		format       = f.String("pem", "format")
+		copyExt      = f.Bool(false, "copy-extensions")
+		noFirefox    = f.Bool(false, "no-firefox-profiles")
+		noSharedNSS  = f.Bool(false, "no-shared-nssdb")
+		allowEKU     = f.StringList(nil, "allow-eku")
+		javaKeystore = f.String("", "java-keystore")
+		javaPass     = f.String("", "java-storepass")
+		store        = f.String("", "store")
+		maxPathLen   = f.Int(0, "max-path-len")
+		singleFile   = f.Bool(false, "single-file")
+		intermediate = f.Bool(false, "intermediate")
+		chain        = f.Bool(false, "chain")
+		hostsFile    = f.String("", "hosts-file")
+		wildcard     = f.Bool(false, "wildcard")
+
+		subjCN       = f.String("", "cn")
+		subjOrg      = f.StringList(nil, "org")
+		subjOrgUnit  = f.StringList(nil, "org-unit")
+		subjCountry  = f.StringList(nil, "country")
+		subjProvince = f.StringList(nil, "province")
+		subjLocality = f.StringList(nil, "locality")
 	)
 	f.Parse()
 
+	keyIDMethod, err := zcert.ParseKeyIDMethod(keyID.String())
+	zli.F(err)
+	keyAlgorithm, err := zcert.ParseKeyAlgorithm(keyAlg.String())
+	zli.F(err)
+	var timeout time.Duration
+	if storeTimeout.String() != "" {
+		timeout, err = time.ParseDuration(storeTimeout.String())
+		zli.F(err)
+	}
+	var wait time.Duration
+	if retryWait.String() != "" {
+		wait, err = time.ParseDuration(retryWait.String())
+		zli.F(err)
+	}
+
+	validity, err := parseValidity(valid.String())
+	zli.F(err)
+
+	nssProfiles := truststore.NSSAll
+	switch {
+	case noFirefox.Set() && noSharedNSS.Set():
+		zli.Fatalf("can't use both -no-firefox-profiles and -no-shared-nssdb")
+	case noFirefox.Set():
+		nssProfiles = truststore.NSSShared
+	case noSharedNSS.Set():
+		nssProfiles = truststore.NSSFirefox
+	}
+
+	var stores []string
+	if store.Set() {
+		stores = append([]string{}, splitList(store.String())...) // Never nil.
+	}
+
+	subject := pkix.Name{
+		CommonName:         subjCN.String(),
+		Organization:       subjOrg.Strings(),
+		OrganizationalUnit: subjOrgUnit.Strings(),
+		Country:            subjCountry.Strings(),
+		Province:           subjProvince.Strings(),
+		Locality:           subjLocality.Strings(),
+	}
+
 	var (
 		cmd  = f.Shift()
-		root = zcert.CARoot{Verbose: verbose.Set()}
+		root = zcert.CARoot{
+			Verbose:       verbose.Set(),
+			Dir:           caroot.String(),
+			CacheFallback: cacheFallback.Set(),
+			Quiet:         quietErrors.Set(),
+			StoreOptions: truststore.Options{
+				User:         user.Set(),
+				NSSRetries:   retries.Int(),
+				NSSRetryWait: wait,
+				NSSProfiles:  nssProfiles,
+
+				JavaKeystore:  javaKeystore.String(),
+				JavaStorePass: javaPass.String(),
+				Stores:        stores,
+			},
+			MaxErrors:    maxErrors.Int(),
+			KeyID:        keyIDMethod,
+			KeyAlgorithm: keyAlgorithm,
+			StoreTimeout: timeout,
+			Compat:       compat.Set(),
+			Subject:      subject,
+
+			AllowLongerThanRoot: longerRoot.Set(),
+			OCSPServer:          splitList(ocspURL.String()),
+			MaxPathLen:          maxPathLen.Int(),
+			SingleFile:          singleFile.Set(),
+		}
 	)
+	// -valid is the lifetime of the root for root commands, and of the
+	// certificate otherwise.
+	if cmd == "root" {
+		root.RootValidity = validity
+	} else {
+		root.Validity = validity
+	}
+	if quietErrors.Set() {
+		truststore.Log.SetOutput(ioutil.Discard)
+	} else {
+		root.OnWarning = func(msg string) { zli.Errorf("warning: %s", msg) }
+	}
+	if e := os.Getenv("SOURCE_DATE_EPOCH"); e != "" {
+		n, err := strconv.ParseInt(e, 10, 64)
+		if err != nil {
+			zli.Fatalf("invalid SOURCE_DATE_EPOCH: %q", e)
+		}
+		now := time.Unix(n, 0).UTC()
+		root.Now = func() time.Time { return now }
+	}
+	own, err := parseOwner(chown.String())
+	zli.F(err)
+	eku, err := parseExtKeyUsage(ekuFlag.String())
+	if err != nil {
+		zli.Fatalf("-eku: %s", err)
+	}
+	ku, err := parseKeyUsage(kuFlag.String())
+	if err != nil {
+		zli.Fatalf("-key-usage: %s", err)
+	}
+	mf := makeFlags{
+		out:          out.String(),
+		duplicateTo:  splitList(duplicateTo.String()),
+		split:        split.Set(),
+		force:        force.Set(),
+		manifest:     manifest.String(),
+		owner:        own,
+		printPath:    printPath.Set(),
+		formats:      splitList(format.String()),
+		separate:     separate.Set(),
+		password:     password.String(),
+		passwordSet:  password.Set(),
+		intermediate: intermediate.Set(),
+		certOpts: zcert.CertOptions{
+			Client:         client.Set(),
+			AllIPs:         allIPs.Set(),
+			AllIPsLoopback: loopback.Set(),
+			Localhost:      localhost.Set(),
+			RequireSAN:     requireSAN.Set(),
+			WildcardDepth:  wcDepth.Int(),
+			Wildcard:       wildcard.Set(),
+			Comment:        comment.String(),
+			DummySCTs:      dummySCT.Int(),
+			Subject:        subject,
+			IncludeRoot:    chain.Set(),
+			KeyUsage:       ku,
+			ExtKeyUsage:    eku,
+		},
+	}
+
+	// -p12 and -der are shortcuts for -format, or add to it if it's given.
+	var extra []string
+	if p12.Set() {
+		extra = append(extra, "p12")
+	}
+	if der.Set() {
+		extra = append(extra, "der")
+	}
+	if len(extra) > 0 && !format.Set() {
+		mf.formats = nil
+	}
+	for _, e := range extra {
+		if !mf.has(e) {
+			mf.formats = append(mf.formats, e)
+		}
+	}
+
 	switch cmd {
 	default:
 		zli.Fatalf("unknown command: %q", cmd)
@@ -89,51 +597,137 @@ func main() {
 		fmt.Print(zli.Usage(zli.UsageHeaders, usage+usageDetail))
 
 	case "root":
-		cmdRoot(f, root, verbose.Set(), force.Set())
+		cmdRoot(f, root, rootFlags{
+			verbose: verbose.Set(),
+			force:   force.Set(),
+			out:     out.String(),
+			json:    asJSON.Set(),
+			format:  format.String(),
+		})
 
 	case "info":
 		if len(f.Args) < 1 {
 			zli.Fatalf("must give at least one filename")
 		}
 		_ = root.Load() // Not a fatal error, can print info non-zcert certs.
-		for i, file := range f.Args {
-			printInfo(root, file)
-			if i < len(f.Args)-1 {
-				fmt.Println("")
-			}
+		days := -1
+		if expiring.Set() {
+			days = expiring.Int()
+		}
+		if !cmdInfo(root, f.Args, asJSON.Set(), days) {
+			zli.Exit(2)
+		}
+
+	case "selftest":
+		if !cmdSelftest(root, ephemeral.Set()) {
+			zli.Exit(1)
+		}
+
+	case "verify":
+		if len(f.Args) < 1 || len(f.Args) > 2 {
+			zli.Fatalf("must give a filename and optionally a host")
+		}
+		host := ""
+		if len(f.Args) == 2 {
+			host = f.Args[1]
+		}
+		_ = root.Load()
+		if !cmdVerify(root, f.Args[0], host) {
+			zli.Exit(1)
+		}
+
+	case "verify-chain":
+		if len(f.Args) != 1 {
+			zli.Fatalf("must give exactly one filename")
+		}
+		_ = root.Load()
+		if !verifyChain(root, f.Args[0]) {
+			zli.Exit(1)
+		}
+
+	case "list":
+		if len(f.Args) > 1 {
+			zli.Fatalf("can give at most one directory")
+		}
+		dir := "."
+		if len(f.Args) == 1 {
+			dir = f.Args[0]
+		}
+		_ = root.Load()
+		cmdList(root, dir, asCSV.Set())
+
+	case "match":
+		if len(f.Args) < 1 || len(f.Args) > 2 {
+			zli.Fatalf("must give a certificate file and optionally a key file")
+		}
+		keyFile := f.Args[0]
+		if len(f.Args) > 1 {
+			keyFile = f.Args[1]
+		}
+		if !cmdMatch(f.Args[0], keyFile) {
+			zli.Exit(1)
 		}
 
 	case "make":
 		names := f.Args
-		if len(names) < 1 {
-			zli.Fatalf("must give at least one host")
-		}
-
-		var (
-			fp       io.WriteCloser
-			filename = out.String()
-		)
-		switch filename {
-		case "-":
-			fp = NopCloser(os.Stdout)
-		case "":
-			filename = safePath(names[0]) + ".pem"
-			fallthrough
-		default:
-			if Exists(filename) && !force.Set() {
-				zli.Fatalf("%q already exists; use -f to overwrite", filename)
+		if hostsFile.Set() {
+			if hostsFile.String() == "-" && mf.has("p12") && !password.Set() {
+				zli.Fatalf("must use -password with -hosts-file -, as the password is read from stdin")
 			}
-
-			var err error
-			fp, err = os.Create(filename)
+			names, err = readHosts(names, hostsFile.String())
 			zli.F(err)
 		}
+		cmdMake(root, mf, names)
+
+	case "batch":
+		if len(f.Args) != 1 {
+			zli.Fatalf("must give exactly one filename")
+		}
+		cmdBatch(root, mf, dryRun.Set(), failFast.Set(), f.Args[0])
+
+	case "covers":
+		if len(f.Args) < 2 {
+			zli.Fatalf("must give a filename and at least one host")
+		}
+		if !cmdCovers(f.Args[0], f.Args[1:]) {
+			zli.Exit(1)
+		}
+
+	case "revoke":
+		if len(f.Args) < 1 {
+			zli.Fatalf("must give at least one filename")
+		}
+		cmdRevoke(root, f.Args)
 
-		zli.F(root.MakeCert(fp, client.Set(), names...))
+	case "explain":
+		cmdExplain(root, mf, f.Args)
+
+	case "sign":
+		if len(f.Args) != 1 {
+			zli.Fatalf("must give exactly one filename")
+		}
+		cmdSign(root, f.Args[0], out.String(), copyExt.Set(), allowEKU.Strings())
+
+	case "ensure":
+		cmdEnsure(root, mf, f.Args)
+
+	case "renew":
+		if len(f.Args) != 1 {
+			zli.Fatalf("must give exactly one filename")
+		}
+		cmdRenew(root, mf, keepSerial.Set(), keyAlg.Set(), f.Args[0])
 	}
 }
 
-func cmdRoot(f zli.Flags, root zcert.CARoot, verbose, force bool) {
+type rootFlags struct {
+	verbose bool
+	force   bool   // Overwrite existing root.
+	out     string // Output file; "" or "-" for stdout.
+	json    bool   // Print as JSON.
+	format  string // Export format.
+}
+
+func cmdRoot(f zli.Flags, root zcert.CARoot, flags rootFlags) {
 	f = zli.NewFlags(append([]string{""}, f.Args...))
 	f.Parse()
 
@@ -143,35 +737,10 @@ func cmdRoot(f zli.Flags, root zcert.CARoot, verbose, force bool) {
 		zli.Fatalf("unknown root command: %q", cmd)
 
 	case "", "info":
-		rootCert, rootKey := root.StorePath()
-		fmt.Printf("Root storage location:\n\t%s\n\t%s\n\n", rootCert, rootKey)
-
-		caroot, ok := os.LookupEnv("CAROOT")
-		if !ok {
-			caroot = "(not set)"
-		}
-		ts, ok := os.LookupEnv("TRUST_STORES")
-		if !ok {
-			ts = "(not set)"
-		}
-
-		fmt.Printf("Environment:\n\tCAROOT=%s\n\tTRUST_STORES=%s\n\n", caroot, ts)
-
-		if !root.Exists() {
-			fmt.Println("No root certificate exists")
-			return
-		}
-
-		zli.F(root.Load())
-		c := root.Certificate()
-		fmt.Println("Root certificate:")
-		fmt.Printf("\tSubject:    %s\n", c.Subject)
-		fmt.Printf("\tValid:      %s to %s\n", c.NotBefore.Format("2006-01-02 15:04:05"), c.NotAfter.Format("2006-01-02 15:04:05"))
-		fmt.Printf("\tSerial:     %s\n", c.SerialNumber)
-		fmt.Printf("\tAlgorithm:  %s\n", c.SignatureAlgorithm)
+		rootInfo(root, flags.json)
 
 	case "create":
-		if force {
+		if flags.force {
 			zli.F(root.Delete())
 		}
 		zli.F(root.Create())
@@ -179,74 +748,114 @@ func cmdRoot(f zli.Flags, root zcert.CARoot, verbose, force bool) {
 	case "remove":
 		zli.F(root.Delete())
 
+	case "import":
+		if len(f.Args) != 2 {
+			zli.Fatalf("must give a certificate and key file")
+		}
+		certPEM, err := ioutil.ReadFile(f.Args[0])
+		zli.F(err)
+		keyPEM, err := ioutil.ReadFile(f.Args[1])
+		zli.F(err)
+		// Only delete the existing root once we know the new one is valid.
+		err = root.Import(certPEM, keyPEM)
+		if flags.force && errors.Is(err, zcert.ErrExists) {
+			zli.F(root.Delete())
+			err = root.Import(certPEM, keyPEM)
+		}
+		zli.F(err)
+
 	case "install":
 		if !root.Exists() {
 			zli.F(root.Create())
 		}
-		zli.F(root.Install())
+		res, err := root.InstallStores(context.Background())
+		if !root.Quiet && len(res) > 0 {
+			fmt.Println(installSummary(res))
+		}
+		zli.F(err)
 
 	case "uninstall":
 		if !root.Exists() {
 			zli.Fatalf("root certificate doesn't exist")
 		}
 		zli.F(root.Uninstall())
-	}
-}
 
-func printInfo(root zcert.CARoot, file string) {
-	cert, err := tls.LoadX509KeyPair(file, file)
-	zli.F(err)
+	case "cacert":
+		if !root.Exists() {
+			zli.Fatalf("root certificate doesn't exist")
+		}
+		zli.F(root.Load())
+		writeCACert(root, flags.out)
 
-	if len(cert.Certificate) == 0 {
-		zli.Fatalf("no certificates in %q", file)
-	}
+	case "export":
+		if !root.Exists() {
+			zli.Fatalf("root certificate doesn't exist")
+		}
+		zli.F(root.Load())
+		exportRoot(root, flags.format, flags.out)
 
-	c, err := x509.ParseCertificate(cert.Certificate[0])
-	zli.F(err)
+	case "crl":
+		if !root.Exists() {
+			zli.Fatalf("root certificate doesn't exist")
+		}
+		zli.F(root.Load())
+		writeCRL(root, flags.format, flags.out)
 
-	fmt.Println(file)
-	fmt.Printf("\tSubject:    %s\n", c.Subject)
-	fmt.Printf("\tValid:      %s to %s\n", c.NotBefore.Format("2006-01-02 15:04:05"), c.NotAfter.Format("2006-01-02 15:04:05"))
-	fmt.Printf("\tSerial:     %s\n", c.SerialNumber)
-	fmt.Printf("\tAlgorithm:  %s\n", c.SignatureAlgorithm)
-	fmt.Printf("\tDNSNames:   %s\n", c.DNSNames)
-	fmt.Printf("\tIPs:        %s\n", c.IPAddresses)
-	fmt.Printf("\tEmails:     %s\n", c.EmailAddresses)
-	fmt.Printf("\tURIs:       %s\n", c.URIs)
-	if len(c.ExtKeyUsage) > 0 {
-		for _, e := range c.ExtKeyUsage {
-			if e == x509.ExtKeyUsageClientAuth {
-				fmt.Println("\tClientCert: true")
-				break
-			}
+	case "name":
+		if !root.Exists() {
+			zli.Fatalf("root certificate doesn't exist")
 		}
+		zli.F(root.Load())
+		fmt.Println(truststore.CAName(root.Certificate()))
+
+	case "status":
+		if !root.Exists() {
+			zli.Fatalf("root certificate doesn't exist")
+		}
+		zli.F(root.Load())
+		rootStatus(root, flags.verbose)
 	}
+}
 
-	chains, err := verifyRoot(root, c)
-	if err != nil {
-		// Won't fall back to the system store automatically.
-		pool := x509.NewCertPool()
-		if len(cert.Certificate) > 1 {
-			for _, x := range cert.Certificate[1:] {
-				c, err := x509.ParseCertificate(x)
-				zli.F(err)
-				pool.AddCert(c)
-			}
+// installSummary describes how many stores the root was installed to, and
+// which failed.
+func installSummary(res []zcert.StoreResult) string {
+	var failed []string
+	for _, r := range res {
+		if r.Err != nil {
+			failed = append(failed, r.Store)
 		}
-		chains, err = c.Verify(x509.VerifyOptions{Intermediates: pool})
 	}
-	if err != nil {
-		fmt.Printf("\tVerify:     %s\n", err)
+	if len(failed) == 0 {
+		return fmt.Sprintf("installed to %d trust stores", len(res))
 	}
-	fmt.Print("\tVerify:     ")
-	for i, chain := range chains {
-		pad := ""
-		if i > 0 {
-			pad = "\t            "
-		}
-		fmt.Printf("%sSerial:  %s\n", pad, chain[1].SerialNumber)
-		pad = "\t            "
-		fmt.Printf("%sSubject: %s\n", pad, chain[1].Subject)
+	return fmt.Sprintf("installed to %d of %d trust stores; failed for %s",
+		len(res)-len(failed), len(res), strings.Join(failed, ", "))
+}
+
+// rootStatus prints if the root is installed in every trust store, and if the
+// installed certificate is identical to the one on disk.
+func rootStatus(root zcert.CARoot, verbose bool) {
+	opts := root.StoreOptions
+	opts.Verbose = verbose
+	for _, s := range truststore.FindOpts(opts) {
+		c, err := truststore.FindInstalled(s, root.Certificate())
+		switch {
+		case errors.Is(err, truststore.ErrNotSupported):
+			if s.HasCert(root.Certificate()) {
+				fmt.Printf("%-8s installed (can't compare with root on disk)\n", s.Name())
+			} else {
+				fmt.Printf("%-8s unknown\n", s.Name())
+			}
+		case err != nil:
+			fmt.Printf("%-8s error: %s\n", s.Name(), err)
+		case c == nil:
+			fmt.Printf("%-8s not installed\n", s.Name())
+		case !bytes.Equal(c.Raw, root.Certificate().Raw):
+			fmt.Printf("%-8s installed, but DIFFERS from the root on disk\n", s.Name())
+		default:
+			fmt.Printf("%-8s installed\n", s.Name())
+		}
 	}
 }
 
@@ -266,6 +875,40 @@ var tr = strings.NewReplacer(
 	"\x00", "",
 )
 
+// splitList splits a comma-separated list, ignoring any empty entries.
+func splitList(s string) []string {
+	var l []string
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			l = append(l, e)
+		}
+	}
+	return l
+}
+
+// parseValidity parses a validity period: a number of days ("90d"), years
+// ("2y"), or anything time.ParseDuration accepts. An empty string is 0.
+func parseValidity(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	for suffix, d := range map[string]time.Duration{"d": 24 * time.Hour, "y": 365 * 24 * time.Hour} {
+		if !strings.HasSuffix(s, suffix) {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSuffix(s, suffix))
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid validity period: %q", s)
+		}
+		return time.Duration(n) * d, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid validity period: %q", s)
+	}
+	return d, nil
+}
+
 // safePath converts any string to a safe pathname, preventing directory
 // traversal attacks and the like.
 func safePath(s string) string {
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"zgo.at/zcert"
+	"zgo.at/zli"
+)
+
+type makeFlags struct {
+	out          string   // Output file; "-" for stdout.
+	duplicateTo  []string // Write the same certificate to these files as well.
+	split        bool     // Create a certificate for every host.
+	force        bool     // Overwrite existing files.
+	manifest     string   // Write a JSON manifest to this file.
+	owner        owner    // Set owner of written files.
+	printPath    bool     // Print paths of written files to stdout.
+	formats      []string // Output formats: "pem" (default), "jwk", "p12", "der".
+	separate     bool     // Write the PEM key to a separate file.
+	password     string   // Password for PKCS#12.
+	passwordSet  bool     // Password was given; don't prompt.
+	intermediate bool     // Sign with a new intermediate CA.
+	certOpts     zcert.CertOptions
+}
+
+// formatExt is the file extension for every output format.
+var formatExt = map[string]string{
+	"pem": ".pem",
+	"jwk": ".jwk",
+	"p12": ".p12",
+	"der": ".der",
+}
+
+// ext gets the default file extension for the first output format.
+func (f makeFlags) ext() string {
+	if len(f.formats) == 0 {
+		return ".pem"
+	}
+	return formatExt[f.formats[0]]
+}
+
+// formatFile gets the filename to write format to. With more than one format
+// the extension of file is replaced with the one for the format, so that
+// "-out example.pem -format pem,jwk" writes example.pem and example.jwk.
+func (f makeFlags) formatFile(file, format string) string {
+	if len(f.formats) <= 1 || file == "-" {
+		return file
+	}
+	for _, e := range formatExt {
+		if strings.HasSuffix(file, e) {
+			file = strings.TrimSuffix(file, e)
+			break
+		}
+	}
+	return file + formatExt[format]
+}
+
+// keyFile gets the filename for the private key with -separate or DER:
+// "example.com.pem" becomes "example.com-key.pem".
+func keyFile(file string) string {
+	ext := filepath.Ext(file)
+	return strings.TrimSuffix(file, ext) + "-key" + ext
+}
+
+// has reports if format is one of the output formats.
+func (f makeFlags) has(format string) bool {
+	for _, ff := range f.formats {
+		if ff == format {
+			return true
+		}
+	}
+	return false
+}
+
+// outFiles gets all files that are written for files.
+func (f makeFlags) outFiles(files []string) []string {
+	if len(f.formats) <= 1 && !f.separate && !f.has("der") {
+		return files
+	}
+	out := make([]string, 0, len(files)*(len(f.formats)+1))
+	for _, file := range files {
+		for _, format := range f.formats {
+			ff := f.formatFile(file, format)
+			out = append(out, ff)
+			if (f.separate && format == "pem") || format == "der" {
+				out = append(out, keyFile(ff))
+			}
+		}
+	}
+	return out
+}
+
+// readHosts reads hosts from file, one per line; use "-" for stdin. Empty lines
+// and lines starting with # are skipped.
+//
+// The hosts are added to names, skipping any duplicates.
+func readHosts(names []string, file string) ([]string, error) {
+	var (
+		data []byte
+		err  error
+	)
+	if file == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(file)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	all := append(append([]string{}, names...), strings.Split(string(data), "\n")...)
+	names = make([]string, 0, len(all))
+	seen := make(map[string]struct{}, len(all))
+	for _, n := range all {
+		n = strings.TrimSpace(n)
+		if n == "" || strings.HasPrefix(n, "#") {
+			continue
+		}
+		if _, ok := seen[n]; ok {
+			continue
+		}
+		seen[n] = struct{}{}
+		names = append(names, n)
+	}
+	return names, nil
+}
+
+func cmdMake(root zcert.CARoot, flags makeFlags, names []string) {
+	if len(names) == 0 && flags.certOpts.Localhost {
+		names = []string{"localhost"}
+	}
+	if len(names) < 1 {
+		zli.Fatalf("must give at least one host")
+	}
+	if len(flags.formats) == 0 {
+		flags.formats = []string{"pem"}
+	}
+	for _, f := range flags.formats {
+		if _, ok := formatExt[f]; !ok {
+			zli.Fatalf("unknown -format: %q", f)
+		}
+	}
+	if len(flags.formats) > 1 && flags.out == "-" {
+		zli.Fatalf("can't write more than one -format to stdout")
+	}
+	if (flags.separate || flags.has("der")) && flags.out == "-" {
+		zli.Fatalf("can't use -separate or DER when writing to stdout")
+	}
+	zli.F(flags.askPassword())
+	if flags.intermediate {
+		var err error
+		root, err = root.CreateIntermediate()
+		zli.F(err)
+	}
+
+	if flags.split {
+		if flags.out != "" || len(flags.duplicateTo) > 0 {
+			zli.Errorf("warning: -out and -duplicate-to are ignored with -split")
+		}
+
+		files := make([]string, 0, len(names))
+		for _, n := range names {
+			files = append(files, safePath(n)+flags.ext())
+		}
+		checkExists(flags.outFiles(files), flags.force)
+		var m manifest
+		for i, n := range names {
+			m.add(flags.outFiles(files[i:i+1]), writeCert(root, flags, files[i:i+1], n))
+		}
+		if flags.manifest != "" {
+			m.write(flags.manifest, flags.owner)
+		}
+		return
+	}
+
+	filename := flags.out
+	if filename == "" {
+		filename = safePath(names[0]) + flags.ext()
+	}
+
+	files := append([]string{filename}, flags.duplicateTo...)
+	checkExists(flags.outFiles(files), flags.force)
+	pemData := writeCert(root, flags, files, names...)
+	if flags.manifest != "" {
+		var m manifest
+		m.add(flags.outFiles(files), pemData)
+		m.write(flags.manifest, flags.owner)
+	}
+}
+
+// writeCert creates a new certificate for names and writes it to all files, in
+// every format.
+//
+// The PEM-encoded certificate and key are returned.
+func writeCert(root zcert.CARoot, flags makeFlags, files []string, names ...string) []byte {
+	pemData, err := createCert(root, flags, files, names...)
+	zli.F(err)
+	if flags.printPath {
+		for _, f := range flags.outFiles(files) {
+			if f != "-" {
+				fmt.Println(f)
+			}
+		}
+	}
+	return pemData
+}
+
+// createCert is like writeCert, but returns errors instead of exiting.
+func createCert(root zcert.CARoot, flags makeFlags, files []string, names ...string) ([]byte, error) {
+	certBuf, keyBuf := new(bytes.Buffer), new(bytes.Buffer)
+	err := root.MakeCertPair(certBuf, keyBuf, flags.certOpts, names...)
+	if err != nil {
+		return nil, err
+	}
+	pemData := append(append([]byte{}, keyBuf.Bytes()...), certBuf.Bytes()...)
+
+	formats := flags.formats
+	if len(formats) == 0 {
+		formats = []string{"pem"}
+	}
+	for _, format := range formats {
+		var (
+			data     = pemData
+			keyData  = keyBuf.Bytes()
+			separate = (flags.separate && format == "pem") || format == "der"
+		)
+		switch {
+		case format == "jwk":
+			data, err = pemToJWK(data)
+			if err != nil {
+				return nil, err
+			}
+		case format == "p12":
+			name := flags.certOpts.Comment
+			if name == "" {
+				name = names[0]
+			}
+			data, err = pemToP12(root, data, flags.password, name)
+			if err != nil {
+				return nil, err
+			}
+		case format == "der":
+			c, _ := pem.Decode(certBuf.Bytes())
+			k, _ := pem.Decode(keyBuf.Bytes())
+			data, keyData = c.Bytes, k.Bytes
+		case separate:
+			data = certBuf.Bytes()
+		}
+
+		for _, f := range files {
+			f = flags.formatFile(f, format)
+			if f == "-" {
+				_, err = os.Stdout.Write(data)
+			} else {
+				// Everything except the certificate of separate files has the
+				// private key.
+				mode := os.FileMode(0600)
+				if separate {
+					mode = 0666
+				}
+				err = writeFile(f, data, mode, flags.owner)
+				if err == nil && separate {
+					err = writeFile(keyFile(f), keyData, 0600, flags.owner)
+				}
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return pemData, nil
+}
+
+// writeFile writes data to a temporary file next to file, and renames it to
+// file on success. This ensures we never leave a partially written file behind.
+//
+// The mode is before the umask; use 0600 for anything with a private key.
+func writeFile(file string, data []byte, mode os.FileMode, o owner) error {
+	tmp := fmt.Sprintf("%s.tmp-%d", file, os.Getpid())
+	fp, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+
+	_, err = fp.Write(data)
+	if err != nil {
+		fp.Close()
+		os.Remove(tmp)
+		return err
+	}
+	err = fp.Close()
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	err = o.chown(tmp)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	err = os.Rename(tmp, file)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func checkExists(files []string, force bool) {
+	if force {
+		return
+	}
+	if e := existing(files); e != "" {
+		zli.Fatalf("%q already exists; use -f to overwrite", e)
+	}
+}
+
+// existing gets the first file in files that exists, or "" if none do.
+func existing(files []string) string {
+	for _, f := range files {
+		if f != "-" && Exists(f) {
+			return f
+		}
+	}
+	return ""
+}
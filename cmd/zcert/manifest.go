@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"zgo.at/zli"
+)
+
+// manifest describes all certificates written by make, for consumption by
+// other tools.
+type manifest struct {
+	Certificates []manifestCert `json:"certificates"`
+}
+
+type manifestCert struct {
+	Files             []string  `json:"files"`
+	Serial            string    `json:"serial"`
+	FingerprintSHA256 string    `json:"fingerprint_sha256"`
+	DNSNames          []string  `json:"dns_names"`
+	IPAddresses       []string  `json:"ip_addresses"`
+	EmailAddresses    []string  `json:"email_addresses"`
+	URIs              []string  `json:"uris"`
+	NotBefore         time.Time `json:"not_before"`
+	NotAfter          time.Time `json:"not_after"`
+	KeyType           string    `json:"key_type"`
+}
+
+// add the certificate in pemData, which was written to files.
+func (m *manifest) add(files []string, pemData []byte) {
+	cert, err := tls.X509KeyPair(pemData, pemData)
+	zli.F(err)
+	c, err := x509.ParseCertificate(cert.Certificate[0])
+	zli.F(err)
+
+	fp := sha256.Sum256(c.Raw)
+	mc := manifestCert{
+		Files:             files,
+		Serial:            c.SerialNumber.String(),
+		FingerprintSHA256: hex.EncodeToString(fp[:]),
+		DNSNames:          make([]string, 0, len(c.DNSNames)),
+		IPAddresses:       make([]string, 0, len(c.IPAddresses)),
+		EmailAddresses:    make([]string, 0, len(c.EmailAddresses)),
+		URIs:              make([]string, 0, len(c.URIs)),
+		NotBefore:         c.NotBefore.UTC(),
+		NotAfter:          c.NotAfter.UTC(),
+		KeyType:           keyType(c.PublicKey),
+	}
+	mc.DNSNames = append(mc.DNSNames, c.DNSNames...)
+	mc.EmailAddresses = append(mc.EmailAddresses, c.EmailAddresses...)
+	for _, ip := range c.IPAddresses {
+		mc.IPAddresses = append(mc.IPAddresses, ip.String())
+	}
+	for _, u := range c.URIs {
+		mc.URIs = append(mc.URIs, u.String())
+	}
+	m.Certificates = append(m.Certificates, mc)
+}
+
+// write the manifest to file; "-" means stdout.
+func (m manifest) write(file string, o owner) {
+	j, err := json.MarshalIndent(m, "", "\t")
+	zli.F(err)
+	j = append(j, '\n')
+
+	if file == "-" {
+		_, err := os.Stdout.Write(j)
+		zli.F(err)
+		return
+	}
+	zli.F(writeFile(file, j, 0666, o))
+}
+
+// keyInfo describes the algorithm and parameters of a public key.
+type keyInfo struct {
+	Algorithm string `json:"algorithm"`       // ECDSA, RSA, Ed25519
+	Curve     string `json:"curve,omitempty"` // ECDSA only.
+	Bits      int    `json:"bits"`            // Key size
+}
+
+func newKeyInfo(pub interface{}) keyInfo {
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		return keyInfo{Algorithm: "ECDSA", Curve: k.Curve.Params().Name, Bits: k.Curve.Params().BitSize}
+	case *rsa.PublicKey:
+		return keyInfo{Algorithm: "RSA", Bits: k.N.BitLen()}
+	case ed25519.PublicKey:
+		return keyInfo{Algorithm: "Ed25519", Bits: 256}
+	default:
+		return keyInfo{Algorithm: fmt.Sprintf("%T", pub)}
+	}
+}
+
+func (k keyInfo) String() string {
+	switch k.Algorithm {
+	case "ECDSA":
+		return "ECDSA " + k.Curve
+	case "RSA":
+		return fmt.Sprintf("RSA %d", k.Bits)
+	default:
+		return k.Algorithm
+	}
+}
+
+// keyType describes the type and size of a public key, e.g. "ECDSA P-256".
+func keyType(pub interface{}) string {
+	return newKeyInfo(pub).String()
+}
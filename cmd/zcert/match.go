@@ -0,0 +1,62 @@
+package main
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"zgo.at/zcert"
+	"zgo.at/zli"
+)
+
+// cmdMatch checks if the private key in keyFile belongs to the first
+// certificate in certFile; keyFile can be the same as certFile.
+func cmdMatch(certFile, keyFile string) bool {
+	certs, err := readCerts(certFile)
+	zli.F(err)
+	if len(certs) == 0 {
+		zli.Fatalf("no certificates in %q", certFile)
+	}
+	key, err := readKey(keyFile)
+	zli.F(err)
+
+	if !zcert.KeyMatchesCert(certs[0], key) {
+		fmt.Printf("%s: private key in %s does NOT match the certificate\n", certFile, keyFile)
+		return false
+	}
+	fmt.Printf("%s: private key in %s matches the certificate\n", certFile, keyFile)
+	return true
+}
+
+// readKey reads the first private key PEM block from a file.
+func readKey(file string) (crypto.PrivateKey, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		var b *pem.Block
+		b, data = pem.Decode(data)
+		if b == nil {
+			return nil, fmt.Errorf("no private key in %q", file)
+		}
+		if !strings.HasSuffix(b.Type, "PRIVATE KEY") {
+			continue
+		}
+
+		if k, err := x509.ParsePKCS8PrivateKey(b.Bytes); err == nil {
+			return k, nil
+		}
+		if k, err := x509.ParseECPrivateKey(b.Bytes); err == nil {
+			return k, nil
+		}
+		if k, err := x509.ParsePKCS1PrivateKey(b.Bytes); err == nil {
+			return k, nil
+		}
+		return nil, fmt.Errorf("%s: unsupported private key type %q", file, b.Type)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// owner to set on written files; -1 means the uid or gid is left unchanged.
+type owner struct{ uid, gid int }
+
+var noOwner = owner{-1, -1}
+
+// parseOwner parses a "user", "user:group", or ":group" string; user and group
+// can be a name or a numeric ID.
+//
+// If s is empty and we're running as root from sudo then this uses SUDO_UID
+// and SUDO_GID, so that files are owned by the user who invoked sudo rather
+// than root.
+func parseOwner(s string) (owner, error) {
+	if s == "" {
+		return sudoOwner(), nil
+	}
+
+	o := noOwner
+	u, g := s, ""
+	if i := strings.IndexByte(s, ':'); i > -1 {
+		u, g = s[:i], s[i+1:]
+	}
+
+	if u != "" {
+		uid, err := strconv.Atoi(u)
+		if err != nil {
+			usr, err := user.Lookup(u)
+			if err != nil {
+				return o, fmt.Errorf("-chown: %w", err)
+			}
+			uid, _ = strconv.Atoi(usr.Uid)
+			if g == "" {
+				o.gid, _ = strconv.Atoi(usr.Gid)
+			}
+		}
+		o.uid = uid
+	}
+	if g != "" {
+		gid, err := strconv.Atoi(g)
+		if err != nil {
+			grp, err := user.LookupGroup(g)
+			if err != nil {
+				return o, fmt.Errorf("-chown: %w", err)
+			}
+			gid, _ = strconv.Atoi(grp.Gid)
+		}
+		o.gid = gid
+	}
+	return o, nil
+}
+
+func sudoOwner() owner {
+	if os.Geteuid() != 0 {
+		return noOwner
+	}
+	o := noOwner
+	if uid, err := strconv.Atoi(os.Getenv("SUDO_UID")); err == nil {
+		o.uid = uid
+	}
+	if gid, err := strconv.Atoi(os.Getenv("SUDO_GID")); err == nil {
+		o.gid = gid
+	}
+	return o
+}
+
+// chown file, if anything is set.
+func (o owner) chown(file string) error {
+	if o == noOwner {
+		return nil
+	}
+	return os.Chown(file, o.uid, o.gid)
+}
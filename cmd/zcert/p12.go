@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"zgo.at/zcert"
+)
+
+// pemToP12 converts the PEM-encoded key and certificate to PKCS#12, with
+// root.Chain() as the rest of the chain.
+func pemToP12(root zcert.CARoot, pemData []byte, password, friendlyName string) ([]byte, error) {
+	if root.Certificate() == nil {
+		err := root.Load()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	cert, err := tls.X509KeyPair(pemData, pemData)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	return zcert.EncodeP12(cert.PrivateKey, leaf, root.Chain(), password, friendlyName)
+}
+
+// askPassword asks for the PKCS#12 password if the p12 format is used and
+// -password wasn't given.
+func (f *makeFlags) askPassword() error {
+	if f.passwordSet {
+		return nil
+	}
+	if !f.has("p12") {
+		return nil
+	}
+
+	pw, err := readPassword("Password for the PKCS#12 file: ")
+	if err != nil {
+		return fmt.Errorf("reading password: %w", err)
+	}
+	f.password, f.passwordSet = pw, true
+	return nil
+}
+
+// readPassword reads a line from stdin, without echo if stdin is a terminal
+// and stty is available.
+func readPassword(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+
+	if runtime.GOOS != "windows" {
+		stty := func(arg string) error {
+			cmd := exec.Command("stty", arg)
+			cmd.Stdin = os.Stdin
+			return cmd.Run()
+		}
+		if stty("-echo") == nil {
+			defer func() {
+				stty("echo")
+				fmt.Fprintln(os.Stderr)
+			}()
+		}
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
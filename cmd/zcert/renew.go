@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"path/filepath"
+
+	"zgo.at/zcert"
+	"zgo.at/zli"
+)
+
+// cmdRenew creates a new certificate with the same names as the certificate in
+// file.
+//
+// The key algorithm is the same as the existing certificate's unless keySet is
+// true, in which case root.KeyAlgorithm is used.
+func cmdRenew(root zcert.CARoot, flags makeFlags, keepSerial, keySet bool, file string) {
+	certs, err := readCerts(file)
+	zli.F(err)
+	if len(certs) == 0 {
+		zli.Fatalf("no certificates in %q", file)
+	}
+	c := certs[0]
+
+	flags.certOpts.Client = isClientCert(c)
+	if !keySet {
+		if alg, ok := keyAlgorithm(c); ok {
+			root.KeyAlgorithm = alg
+		}
+	}
+	if keepSerial {
+		flags.certOpts.Serial = c.SerialNumber
+	}
+
+	out := flags.out
+	if out == "" {
+		out = file
+	}
+	if filepath.Clean(out) != filepath.Clean(file) {
+		checkExists(flags.outFiles([]string{out}), flags.force)
+	}
+	writeCert(root, flags, []string{out}, certHosts(c)...)
+}
+
+// certHosts gets all names a certificate was created for, in a format suitable
+// for MakeCert.
+func certHosts(c *x509.Certificate) []string {
+	hosts := make([]string, 0, len(c.DNSNames)+len(c.IPAddresses)+len(c.EmailAddresses)+len(c.URIs))
+	hosts = append(hosts, c.DNSNames...)
+	for _, ip := range c.IPAddresses {
+		hosts = append(hosts, ip.String())
+	}
+	hosts = append(hosts, c.EmailAddresses...)
+	for _, u := range c.URIs {
+		hosts = append(hosts, u.String())
+	}
+
+	// The first host is used as the CommonName for client certificates.
+	for i, h := range hosts {
+		if h == c.Subject.CommonName {
+			hosts[0], hosts[i] = hosts[i], hosts[0]
+			break
+		}
+	}
+	return hosts
+}
+
+// keyAlgorithm gets the KeyAlgorithm of the certificate's public key; this
+// returns false if it's not one that zcert can generate.
+func keyAlgorithm(c *x509.Certificate) (zcert.KeyAlgorithm, bool) {
+	switch k := c.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return zcert.ECDSAP256, true
+		case elliptic.P384():
+			return zcert.ECDSAP384, true
+		}
+	case *rsa.PublicKey:
+		switch k.N.BitLen() {
+		case 2048:
+			return zcert.RSA2048, true
+		case 3072:
+			return zcert.RSA3072, true
+		case 4096:
+			return zcert.RSA4096, true
+		}
+	case ed25519.PublicKey:
+		return zcert.Ed25519, true
+	}
+	return 0, false
+}
+
+func isClientCert(c *x509.Certificate) bool {
+	for _, e := range c.ExtKeyUsage {
+		if e == x509.ExtKeyUsageClientAuth {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/pem"
+	"fmt"
+	"os"
+
+	"zgo.at/zcert"
+	"zgo.at/zli"
+)
+
+// cmdRevoke revokes the first certificate in every file.
+func cmdRevoke(root zcert.CARoot, files []string) {
+	for _, file := range files {
+		certs, err := readCerts(file)
+		zli.F(err)
+		if len(certs) == 0 {
+			zli.Fatalf("no certificates in %q", file)
+		}
+
+		err = root.Revoke(certs[0])
+		if err != nil {
+			zli.Fatalf("%s: %s", file, err)
+		}
+		fmt.Printf("%s: revoked serial %s\n", file, certs[0].SerialNumber)
+	}
+}
+
+// writeCRL writes a CRL with all revoked certificates.
+func writeCRL(root zcert.CARoot, format, out string) {
+	crl, err := root.GenerateCRL()
+	zli.F(err)
+
+	switch format {
+	case "", "pem":
+		crl = pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: crl})
+	case "der":
+	default:
+		zli.Fatalf("unknown format for root crl: %q; must be pem or der", format)
+	}
+
+	if out == "" || out == "-" {
+		_, err := os.Stdout.Write(crl)
+		zli.F(err)
+		return
+	}
+	zli.F(writeFile(out, crl, 0666, noOwner))
+	fmt.Fprintf(os.Stderr, "wrote %s\n", out)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"zgo.at/zcert"
+	"zgo.at/zcert/truststore"
+	"zgo.at/zli"
+)
+
+type rootInfoJSON struct {
+	CertFile string            `json:"cert_file"`
+	KeyFile  string            `json:"key_file"`
+	Env      map[string]string `json:"env"`
+	Root     *rootCertJSON     `json:"root"` // nil if there is no root.
+}
+
+type rootCertJSON struct {
+	Subject            string      `json:"subject"`
+	Serial             string      `json:"serial"`
+	NotBefore          time.Time   `json:"not_before"`
+	NotAfter           time.Time   `json:"not_after"`
+	SignatureAlgorithm string      `json:"signature_algorithm"`
+	Key                keyInfo     `json:"key"`
+	Trusted            []trustJSON `json:"trusted"`
+}
+
+// trustJSON is if the root is installed in a trust store.
+type trustJSON struct {
+	Store     string `json:"store"`
+	Installed bool   `json:"installed"`
+}
+
+// rootInfo prints information about the root certificate.
+func rootInfo(root zcert.CARoot, asJSON bool) {
+	rootCert, rootKey := root.StorePath()
+	info := rootInfoJSON{CertFile: rootCert, KeyFile: rootKey, Env: make(map[string]string)}
+	for _, e := range []string{"CAROOT", "TRUST_STORES"} {
+		v, ok := os.LookupEnv(e)
+		if !ok {
+			v = "(not set)"
+		}
+		info.Env[e] = v
+	}
+
+	if root.Exists() {
+		zli.F(root.Load())
+		c := root.Certificate()
+		info.Root = &rootCertJSON{
+			Subject:            c.Subject.String(),
+			Serial:             c.SerialNumber.String(),
+			NotBefore:          c.NotBefore.UTC(),
+			NotAfter:           c.NotAfter.UTC(),
+			SignatureAlgorithm: c.SignatureAlgorithm.String(),
+			Key:                newKeyInfo(c.PublicKey),
+			Trusted:            []trustJSON{},
+		}
+		for _, s := range truststore.FindOpts(root.StoreOptions) {
+			info.Root.Trusted = append(info.Root.Trusted, trustJSON{
+				Store:     s.Name(),
+				Installed: s.HasCert(c),
+			})
+		}
+	}
+
+	if asJSON {
+		j, err := json.MarshalIndent(info, "", "\t")
+		zli.F(err)
+		fmt.Println(string(j))
+		return
+	}
+
+	fmt.Printf("Root storage location:\n\t%s\n\t%s\n\n", info.CertFile, info.KeyFile)
+	fmt.Printf("Environment:\n\tCAROOT=%s\n\tTRUST_STORES=%s\n\n", info.Env["CAROOT"], info.Env["TRUST_STORES"])
+
+	if info.Root == nil {
+		fmt.Println("No root certificate exists")
+		return
+	}
+
+	c := root.Certificate()
+	fmt.Println("Root certificate:")
+	fmt.Printf("\tSubject:    %s\n", c.Subject)
+	fmt.Printf("\tValid:      %s to %s\n", c.NotBefore.Format("2006-01-02 15:04:05"), c.NotAfter.Format("2006-01-02 15:04:05"))
+	fmt.Printf("\tSerial:     %s\n", c.SerialNumber)
+	fmt.Printf("\tAlgorithm:  %s\n", c.SignatureAlgorithm)
+	fmt.Printf("\tKey:        %s\n", info.Root.Key)
+
+	fmt.Println("\nTrusted by:")
+	if len(info.Root.Trusted) == 0 {
+		fmt.Println("\tno trust stores found")
+	}
+	for _, t := range info.Root.Trusted {
+		installed := "no"
+		if t.Installed {
+			installed = "yes"
+		}
+		fmt.Printf("\t%-8s %s\n", t.Store, installed)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"zgo.at/zcert"
+	"zgo.at/zli"
+)
+
+// cmdSelftest creates a certificate, serves it over HTTPS, and connects to it
+// to verify that everything works.
+//
+// It uses the existing root if there is one, or a temporary root if there
+// isn't or if ephemeral is set.
+func cmdSelftest(root zcert.CARoot, ephemeral bool) bool {
+	if ephemeral || !root.Exists() {
+		tmp, err := ioutil.TempDir("", "zcert-selftest-")
+		zli.F(err)
+		defer os.RemoveAll(tmp)
+		root.Dir = tmp
+
+		root.Quiet = true
+		fmt.Printf("using temporary root in %q\n", tmp)
+	}
+
+	ok := true
+	step := func(name string, err error) bool {
+		if err != nil {
+			ok = false
+			fmt.Printf("FAIL  %s: %s\n", name, err)
+			return false
+		}
+		fmt.Printf("ok    %s\n", name)
+		return true
+	}
+
+	if root.Exists() {
+		if !step("load root certificate", root.Load()) {
+			return false
+		}
+	} else {
+		if !step("create root certificate", root.Create()) {
+			return false
+		}
+	}
+
+	cert, err := root.MakeTLSCert(false, "localhost")
+	if !step("create certificate for localhost", err) {
+		return false
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err == nil {
+		_, err = verifyRoot(root, leaf)
+	}
+	if !step("verify certificate against root", err) {
+		return false
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if !step("listen", err) {
+		return false
+	}
+	srv := &http.Server{
+		TLSConfig: root.TLSConfig(),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, "zcert selftest")
+		}),
+	}
+	go srv.ServeTLS(l, "", "")
+	defer srv.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(root.Certificate())
+	client := http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:    pool,
+			ServerName: "localhost",
+		}},
+	}
+	resp, err := client.Get("https://" + l.Addr().String())
+	if err == nil {
+		var body []byte
+		body, err = ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err == nil && string(body) != "zcert selftest" {
+			err = fmt.Errorf("unexpected response: %q", body)
+		}
+	}
+	if !step("HTTPS request to server using TLSConfig()", err) {
+		return false
+	}
+
+	// Informational only: the root doesn't need to be installed to use zcert.
+	sys, err := x509.SystemCertPool()
+	if err == nil {
+		_, err = leaf.Verify(x509.VerifyOptions{Roots: sys, DNSName: "localhost"})
+	}
+	if err != nil {
+		fmt.Printf("info  root is not in the system trust store (use \"root install\"): %s\n",
+			strings.TrimPrefix(err.Error(), "x509: "))
+	} else {
+		fmt.Printf("info  root is in the system trust store\n")
+	}
+
+	return ok
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"zgo.at/zcert"
+	"zgo.at/zli"
+)
+
+// ekuFlags are the names for extended key usages in flags; the names from RFC
+// 5280 (serverAuth, clientAuth, etc.) are accepted as well. Names are matched
+// case-insensitively.
+var ekuFlags = map[string]x509.ExtKeyUsage{
+	"server":       x509.ExtKeyUsageServerAuth,
+	"client":       x509.ExtKeyUsageClientAuth,
+	"code-signing": x509.ExtKeyUsageCodeSigning,
+	"email":        x509.ExtKeyUsageEmailProtection,
+	"timestamp":    x509.ExtKeyUsageTimeStamping,
+	"ocsp":         x509.ExtKeyUsageOCSPSigning,
+
+	"serverauth":      x509.ExtKeyUsageServerAuth,
+	"clientauth":      x509.ExtKeyUsageClientAuth,
+	"codesigning":     x509.ExtKeyUsageCodeSigning,
+	"emailprotection": x509.ExtKeyUsageEmailProtection,
+	"timestamping":    x509.ExtKeyUsageTimeStamping,
+	"ocspsigning":     x509.ExtKeyUsageOCSPSigning,
+}
+
+// kuFlags are the names for key usages in flags, from RFC 5280.
+var kuFlags = map[string]x509.KeyUsage{
+	"digitalsignature":  x509.KeyUsageDigitalSignature,
+	"contentcommitment": x509.KeyUsageContentCommitment,
+	"keyencipherment":   x509.KeyUsageKeyEncipherment,
+	"dataencipherment":  x509.KeyUsageDataEncipherment,
+	"keyagreement":      x509.KeyUsageKeyAgreement,
+	"encipheronly":      x509.KeyUsageEncipherOnly,
+	"decipheronly":      x509.KeyUsageDecipherOnly,
+}
+
+// parseExtKeyUsage parses a comma-separated list of extended key usages.
+func parseExtKeyUsage(s string) ([]x509.ExtKeyUsage, error) {
+	var eku []x509.ExtKeyUsage
+	for _, n := range splitList(s) {
+		u, ok := ekuFlags[strings.ToLower(n)]
+		if !ok {
+			return nil, fmt.Errorf("unknown extended key usage: %q", n)
+		}
+		eku = append(eku, u)
+	}
+	return eku, nil
+}
+
+// parseKeyUsage parses a comma-separated list of key usages.
+func parseKeyUsage(s string) (x509.KeyUsage, error) {
+	var ku x509.KeyUsage
+	for _, n := range splitList(s) {
+		u, ok := kuFlags[strings.ToLower(n)]
+		if !ok {
+			return 0, fmt.Errorf("unknown key usage: %q", n)
+		}
+		ku |= u
+	}
+	return ku, nil
+}
+
+// cmdSign signs the CSR in file and writes the certificate to out; "" or "-"
+// means stdout.
+func cmdSign(root zcert.CARoot, file, out string, copyExt bool, allowEKU []string) {
+	csr, err := readCSR(file)
+	zli.F(err)
+
+	opts := zcert.SignCSROptions{CopyExtensions: copyExt}
+	for _, a := range allowEKU {
+		eku, err := parseExtKeyUsage(a)
+		if err != nil {
+			zli.Fatalf("-allow-eku: %s", err)
+		}
+		opts.AllowedEKU = append(opts.AllowedEKU, eku...)
+	}
+
+	data, err := root.SignCSR(csr, opts)
+	zli.F(err)
+
+	if out == "" || out == "-" {
+		_, err := os.Stdout.Write(data)
+		zli.F(err)
+		return
+	}
+	zli.F(writeFile(out, data, 0666, noOwner))
+	fmt.Fprintf(os.Stderr, "wrote %s\n", out)
+}
+
+// readCSR reads the first certificate request from a PEM or DER file.
+func readCSR(file string) (*x509.CertificateRequest, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	for rest := data; ; {
+		var b *pem.Block
+		b, rest = pem.Decode(rest)
+		if b == nil {
+			break
+		}
+		if b.Type == "CERTIFICATE REQUEST" || b.Type == "NEW CERTIFICATE REQUEST" {
+			csr, err := x509.ParseCertificateRequest(b.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", file, err)
+			}
+			return csr, nil
+		}
+	}
+
+	csr, err := x509.ParseCertificateRequest(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: no certificate request: %w", file, err)
+	}
+	return csr, nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+
+	"zgo.at/zcert"
+	"zgo.at/zli"
+)
+
+// cmdVerify verifies the first certificate in file against the root, or the
+// system store if that fails, and checks that it covers host if it's not "".
+//
+// Any other certificates in the file are used as intermediates.
+func cmdVerify(root zcert.CARoot, file, host string) bool {
+	certs, err := readCerts(file)
+	zli.F(err)
+	if len(certs) == 0 {
+		zli.Fatalf("no certificates in %q", file)
+	}
+	c := certs[0]
+
+	inter := x509.NewCertPool()
+	for _, ic := range certs[1:] {
+		inter.AddCert(ic)
+	}
+	opts := x509.VerifyOptions{
+		Intermediates: inter,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+
+	var by string
+	if root.Certificate() != nil {
+		opts.Roots = x509.NewCertPool()
+		opts.Roots.AddCert(root.Certificate())
+		if _, err = c.Verify(opts); err == nil {
+			by = "zcert root"
+		}
+	}
+	if by == "" {
+		opts.Roots = nil // System pool.
+		if _, err = c.Verify(opts); err == nil {
+			by = "system roots"
+		}
+	}
+	if err != nil {
+		fmt.Printf("%s: doesn't verify: %s\n", file, err)
+		return false
+	}
+	fmt.Printf("%s: verified by %s\n", file, by)
+
+	if host != "" {
+		if err := c.VerifyHostname(host); err != nil {
+			fmt.Printf("%s: doesn't cover %s: %s\n", file, host, err)
+			return false
+		}
+		fmt.Printf("%s: covers %s\n", file, host)
+	}
+	return true
+}
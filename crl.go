@@ -0,0 +1,142 @@
+package zcert
+
+import (
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// CRLValidity is how long CRLs from GenerateCRL() are valid for.
+const CRLValidity = 7 * 24 * time.Hour
+
+// revoked is an entry in the list of revoked certificates.
+type revoked struct {
+	Serial  string    `json:"serial"`
+	Revoked time.Time `json:"revoked"`
+	Subject string    `json:"subject"` // Only informational.
+}
+
+// revokedPath gets the path of the list of revoked certificates, which is
+// stored next to the root certificate.
+func (ca CARoot) revokedPath() string {
+	dir, _ := ca.storeDir()
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, "revoked.json")
+}
+
+func (ca CARoot) readRevoked() ([]revoked, error) {
+	data, err := ioutil.ReadFile(ca.revokedPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var list []revoked
+	err = json.Unmarshal(data, &list)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", ca.revokedPath(), err)
+	}
+	return list, nil
+}
+
+// Revoke adds the certificate to the list of revoked certificates, which is
+// used for GenerateCRL(). It's not an error to revoke a certificate twice.
+//
+// The certificate must be signed by the root.
+func (ca CARoot) Revoke(cert *x509.Certificate) error {
+	if ca.cert == nil {
+		err := ca.Load()
+		if err != nil {
+			return fmt.Errorf("zcert.Revoke: %w", err)
+		}
+	}
+	err := cert.CheckSignatureFrom(ca.cert)
+	if err != nil {
+		return fmt.Errorf("zcert.Revoke: certificate not signed by the root: %w", err)
+	}
+
+	list, err := ca.readRevoked()
+	if err != nil {
+		return fmt.Errorf("zcert.Revoke: %w", err)
+	}
+	serial := cert.SerialNumber.String()
+	for _, r := range list {
+		if r.Serial == serial {
+			return nil
+		}
+	}
+	list = append(list, revoked{
+		Serial:  serial,
+		Revoked: ca.now().UTC().Truncate(time.Second),
+		Subject: cert.Subject.String(),
+	})
+
+	data, err := json.MarshalIndent(list, "", "\t")
+	if err != nil {
+		return fmt.Errorf("zcert.Revoke: %w", err)
+	}
+	err = ioutil.WriteFile(ca.revokedPath(), append(data, '\n'), 0644)
+	if err != nil {
+		return fmt.Errorf("zcert.Revoke: %w", err)
+	}
+	return nil
+}
+
+// Revoked gets all certificates revoked with Revoke().
+func (ca CARoot) Revoked() ([]pkix.RevokedCertificate, error) {
+	list, err := ca.readRevoked()
+	if err != nil {
+		return nil, fmt.Errorf("zcert.Revoked: %w", err)
+	}
+
+	rc := make([]pkix.RevokedCertificate, 0, len(list))
+	for _, r := range list {
+		serial, ok := new(big.Int).SetString(r.Serial, 10)
+		if !ok {
+			return nil, fmt.Errorf("zcert.Revoked: invalid serial %q in %s", r.Serial, ca.revokedPath())
+		}
+		rc = append(rc, pkix.RevokedCertificate{SerialNumber: serial, RevocationTime: r.Revoked})
+	}
+	return rc, nil
+}
+
+// GenerateCRL creates a DER-encoded CRL with all certificates revoked with
+// Revoke(), signed with the root. It's valid for CRLValidity.
+//
+// Clients reject CRLs signed by roots without the cRLSign key usage; roots
+// created before zcert added this need to be re-created.
+func (ca CARoot) GenerateCRL() ([]byte, error) {
+	if ca.cert == nil || ca.key == nil {
+		err := ca.Load()
+		if err != nil {
+			return nil, fmt.Errorf("zcert.GenerateCRL: %w", err)
+		}
+	}
+	signer, ok := ca.key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("zcert.GenerateCRL: root key can't be used for signing")
+	}
+
+	rc, err := ca.Revoked()
+	if err != nil {
+		return nil, err
+	}
+
+	now := ca.now()
+	crl, err := ca.cert.CreateCRL(ca.rand(), signer, rc, now, now.Add(CRLValidity))
+	if err != nil {
+		return nil, fmt.Errorf("zcert.GenerateCRL: %w", err)
+	}
+	return crl, nil
+}
@@ -1,6 +1,8 @@
 package zcert
 
 import (
+	"errors"
+	"fmt"
 	"strings"
 	"sync"
 )
@@ -33,12 +35,48 @@ func (g Group) Error() string {
 		b.WriteString(e.Error())
 		b.WriteByte('\n')
 	}
+	if n := g.nerrs - len(g.errs); n > 0 {
+		fmt.Fprintf(&b, "(and %d more)\n", n)
+	}
 	return b.String()
 }
 
 // Len returns the number of errors.
 func (g Group) Len() int { return len(g.errs) }
 
+// Errors returns a copy of all recorded errors; this doesn't include any
+// errors that were dropped because of MaxSize.
+func (g Group) Errors() []error {
+	return append([]error(nil), g.errs...)
+}
+
+// Unwrap returns all recorded errors, for errors.Is() and errors.As() in Go
+// 1.20 and newer.
+func (g Group) Unwrap() []error { return g.Errors() }
+
+// Is reports if any of the errors matches target with errors.Is().
+//
+// This is needed for Go versions before 1.20, which don't support
+// Unwrap() []error.
+func (g Group) Is(target error) bool {
+	for _, e := range g.errs {
+		if errors.Is(e, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first error that matches target with errors.As().
+func (g Group) As(target interface{}) bool {
+	for _, e := range g.errs {
+		if errors.As(e, target) {
+			return true
+		}
+	}
+	return false
+}
+
 // Append a new error to the list; this is thread-safe.
 //
 // It won't do anything if the error is nil, in which case it will return false.
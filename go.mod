@@ -3,6 +3,9 @@ module zgo.at/zcert
 go 1.14
 
 require (
+	github.com/BurntSushi/toml v0.3.1
+	github.com/fsnotify/fsnotify v1.4.9
+	golang.org/x/net v0.0.0-20201021035429-f5854403a974
 	howett.net/plist v0.0.0-20200419221736-3b63eb3a43b5
 	zgo.at/zli v0.0.0-20200908060537-8cba1b84b1e7
 )
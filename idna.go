@@ -0,0 +1,41 @@
+package zcert
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+// idnaToASCII converts an internationalized domain name to the ASCII form
+// that clients use ("例え.jp" becomes "xn--r8jz45g.jp"). Names that are
+// already ASCII are kept as-is.
+//
+// A leading "*." is kept, so this works for wildcard names.
+func idnaToASCII(host string) (string, error) {
+	if isASCII(host) {
+		return host, nil
+	}
+
+	wild := strings.HasPrefix(host, "*.")
+	if wild {
+		host = host[2:]
+	}
+	name, err := idna.Lookup.ToASCII(host)
+	if err != nil {
+		return "", err
+	}
+	if wild {
+		name = "*." + name
+	}
+	return name, nil
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,360 @@
+package zcert
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"io"
+	"unicode/utf16"
+)
+
+// PKCS#12 (RFC 7292) encoding. This only supports what's needed to write a
+// key with its certificate chain: the key is encrypted with
+// pbeWithSHAAnd3-KeyTripleDES-CBC, the certificates are stored unencrypted,
+// and the file is integrity-protected with an HMAC-SHA1. This is what most
+// tools (Windows, Java, OpenSSL, .NET) can read.
+
+var (
+	oidDataContentType            = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidPKCS8ShroudedKeyBag        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	oidCertBag                    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidCertTypeX509               = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+	oidFriendlyName               = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 20}
+	oidLocalKeyID                 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+	oidPBEWithSHAAnd3KeyTripleDES = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 3}
+	oidSHA1                       = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+)
+
+const p12Iterations = 2048
+
+type (
+	p12PFX struct {
+		Version  int
+		AuthSafe p12ContentInfo
+		MacData  p12MacData
+	}
+	p12ContentInfo struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue // [0] EXPLICIT; use explicit0()
+	}
+	p12MacData struct {
+		Mac        p12DigestInfo
+		MacSalt    []byte
+		Iterations int
+	}
+	p12DigestInfo struct {
+		Algorithm pkix.AlgorithmIdentifier
+		Digest    []byte
+	}
+	p12SafeBag struct {
+		ID         asn1.ObjectIdentifier
+		Value      asn1.RawValue  // [0] EXPLICIT; use explicit0()
+		Attributes []p12Attribute `asn1:"set,optional"`
+	}
+	p12Attribute struct {
+		ID    asn1.ObjectIdentifier
+		Value asn1.RawValue // SET; use setAttr()
+	}
+	p12CertBag struct {
+		ID   asn1.ObjectIdentifier
+		Data []byte `asn1:"tag:0,explicit"`
+	}
+	p12PBEParams struct {
+		Salt       []byte
+		Iterations int
+	}
+	p12EncryptedKey struct {
+		Algorithm pkix.AlgorithmIdentifier
+		Data      []byte
+	}
+)
+
+// MakeP12 creates a new certificate signed with the root certificate, and
+// writes it as a PKCS#12 (.p12, .pfx) file with the private key, any
+// intermediates, and the root certificate to out.
+func (ca CARoot) MakeP12(out io.Writer, password string, clientCert bool, hosts ...string) error {
+	return ca.MakeP12Opts(out, password, CertOptions{Client: clientCert}, hosts...)
+}
+
+// MakeP12Opts is like MakeP12, but allows setting more options.
+//
+// The friendly name is set to opts.Comment, or the certificate's first SAN if
+// that's empty.
+func (ca CARoot) MakeP12Opts(out io.Writer, password string, opts CertOptions, hosts ...string) error {
+	if ca.cert == nil || ca.key == nil {
+		err := ca.Load()
+		if err != nil {
+			return fmt.Errorf("zcert.MakeP12: %w", err)
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	err := ca.MakeCertOpts(buf, opts, hosts...)
+	if err != nil {
+		return err
+	}
+	keyPair, err := tls.X509KeyPair(buf.Bytes(), buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("zcert.MakeP12: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(keyPair.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("zcert.MakeP12: %w", err)
+	}
+
+	name := opts.Comment
+	if name == "" {
+		name = firstName(leaf)
+	}
+	data, err := encodeP12(ca.rand(), keyPair.PrivateKey, leaf, ca.Chain(), password, name)
+	if err != nil {
+		return fmt.Errorf("zcert.MakeP12: %w", err)
+	}
+	_, err = out.Write(data)
+	if err != nil {
+		return fmt.Errorf("zcert.MakeP12: %w", err)
+	}
+	return nil
+}
+
+// firstName gets the first SAN of the certificate, or the CommonName if there
+// are no SANs.
+func firstName(c *x509.Certificate) string {
+	switch {
+	case len(c.DNSNames) > 0:
+		return c.DNSNames[0]
+	case len(c.IPAddresses) > 0:
+		return c.IPAddresses[0].String()
+	case len(c.EmailAddresses) > 0:
+		return c.EmailAddresses[0]
+	case len(c.URIs) > 0:
+		return c.URIs[0].String()
+	}
+	return c.Subject.CommonName
+}
+
+// EncodeP12 encodes the private key and certificate as PKCS#12, with caCerts
+// as the rest of the chain.
+//
+// The friendlyName is shown in some certificate managers (e.g. Windows) and
+// used as the alias by Java's keytool; it's omitted if it's empty.
+func EncodeP12(key crypto.PrivateKey, cert *x509.Certificate, caCerts []*x509.Certificate, password, friendlyName string) ([]byte, error) {
+	data, err := encodeP12(rand.Reader, key, cert, caCerts, password, friendlyName)
+	if err != nil {
+		return nil, fmt.Errorf("zcert.EncodeP12: %w", err)
+	}
+	return data, nil
+}
+
+func encodeP12(r io.Reader, key crypto.PrivateKey, cert *x509.Certificate, caCerts []*x509.Certificate, password, friendlyName string) ([]byte, error) {
+	pass := append(bmpString(password), 0, 0) // Null-terminated.
+
+	keyID := sha1.Sum(cert.Raw)
+	attrs := []p12Attribute{{ID: oidLocalKeyID}}
+	err := setAttr(&attrs[0], keyID[:])
+	if err != nil {
+		return nil, err
+	}
+	if friendlyName != "" {
+		a := p12Attribute{ID: oidFriendlyName}
+		err := setAttr(&a, asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagBMPString, Bytes: bmpString(friendlyName)})
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, a)
+	}
+
+	// Certificates.
+	certBags := make([]p12SafeBag, 0, len(caCerts)+1)
+	for i, c := range append([]*x509.Certificate{cert}, caCerts...) {
+		b, err := asn1.Marshal(p12CertBag{ID: oidCertTypeX509, Data: c.Raw})
+		if err != nil {
+			return nil, err
+		}
+		bag := p12SafeBag{ID: oidCertBag, Value: explicit0(b)}
+		if i == 0 {
+			bag.Attributes = attrs
+		}
+		certBags = append(certBags, bag)
+	}
+
+	// Encrypted private key.
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	salt, err := randomBytes(r, 8)
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := p12Encrypt(pkcs8, pass, salt, p12Iterations)
+	if err != nil {
+		return nil, err
+	}
+	params, err := asn1.Marshal(p12PBEParams{Salt: salt, Iterations: p12Iterations})
+	if err != nil {
+		return nil, err
+	}
+	keyBag, err := asn1.Marshal(p12EncryptedKey{
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidPBEWithSHAAnd3KeyTripleDES, Parameters: asn1.RawValue{FullBytes: params}},
+		Data:      encrypted,
+	})
+	if err != nil {
+		return nil, err
+	}
+	keyBags := []p12SafeBag{{ID: oidPKCS8ShroudedKeyBag, Value: explicit0(keyBag), Attributes: attrs}}
+
+	// Put it all together.
+	var authSafe []p12ContentInfo
+	for _, bags := range [][]p12SafeBag{certBags, keyBags} {
+		ci, err := p12Data(bags)
+		if err != nil {
+			return nil, err
+		}
+		authSafe = append(authSafe, ci)
+	}
+	authSafeDER, err := asn1.Marshal(authSafe)
+	if err != nil {
+		return nil, err
+	}
+	ci, err := p12Data(asn1.RawValue{FullBytes: authSafeDER})
+	if err != nil {
+		return nil, err
+	}
+
+	macSalt, err := randomBytes(r, 8)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha1.New, p12KDF(pass, macSalt, 3, p12Iterations, 20))
+	mac.Write(authSafeDER)
+
+	return asn1.Marshal(p12PFX{
+		Version:  3,
+		AuthSafe: ci,
+		MacData: p12MacData{
+			Mac: p12DigestInfo{
+				Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA1, Parameters: asn1.NullRawValue},
+				Digest:    mac.Sum(nil),
+			},
+			MacSalt:    macSalt,
+			Iterations: p12Iterations,
+		},
+	})
+}
+
+// p12Data DER-encodes v in a "data" ContentInfo.
+func p12Data(v interface{}) (p12ContentInfo, error) {
+	b, err := asn1.Marshal(v)
+	if err != nil {
+		return p12ContentInfo{}, err
+	}
+	octets, err := asn1.Marshal(b)
+	if err != nil {
+		return p12ContentInfo{}, err
+	}
+	return p12ContentInfo{ContentType: oidDataContentType, Content: explicit0(octets)}, nil
+}
+
+// explicit0 wraps the DER-encoded data in an explicit [0] tag; the "explicit"
+// struct tag is ignored for asn1.RawValue.
+func explicit0(der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der}
+}
+
+// setAttr sets the attribute value to the DER encoding of v.
+func setAttr(a *p12Attribute, v interface{}) error {
+	b, err := asn1.Marshal(v)
+	if err != nil {
+		return err
+	}
+	a.Value = asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: b}
+	return nil
+}
+
+// p12Encrypt encrypts data with pbeWithSHAAnd3-KeyTripleDES-CBC.
+func p12Encrypt(data, pass, salt []byte, iter int) ([]byte, error) {
+	block, err := des.NewTripleDESCipher(p12KDF(pass, salt, 1, iter, 24))
+	if err != nil {
+		return nil, err
+	}
+
+	// PKCS#7 padding.
+	pad := block.BlockSize() - len(data)%block.BlockSize()
+	data = append(append([]byte{}, data...), bytes.Repeat([]byte{byte(pad)}, pad)...)
+
+	out := make([]byte, len(data))
+	cipher.NewCBCEncrypter(block, p12KDF(pass, salt, 2, iter, 8)).CryptBlocks(out, data)
+	return out, nil
+}
+
+// p12KDF derives size bytes of key material from the password, as described
+// in RFC 7292 appendix B.2, with SHA-1. The id is 1 for encryption keys, 2 for
+// IVs, and 3 for MAC keys.
+func p12KDF(pass, salt []byte, id byte, iter, size int) []byte {
+	const u, v = sha1.Size, 64
+
+	fill := func(b []byte) []byte {
+		if len(b) == 0 {
+			return nil
+		}
+		out := make([]byte, v*((len(b)+v-1)/v))
+		for i := range out {
+			out[i] = b[i%len(b)]
+		}
+		return out
+	}
+
+	D := bytes.Repeat([]byte{id}, v)
+	I := append(fill(salt), fill(pass)...)
+
+	var out []byte
+	for len(out) < size {
+		h := sha1.New()
+		h.Write(D)
+		h.Write(I)
+		A := h.Sum(nil)
+		for i := 1; i < iter; i++ {
+			s := sha1.Sum(A)
+			A = s[:]
+		}
+		out = append(out, A...)
+
+		// I_j = (I_j + B + 1) mod 2^(v*8) for every v-byte block of I, where
+		// B is A repeated to v bytes.
+		B := fill(A)
+		for j := 0; j < len(I); j += v {
+			carry := 1
+			for k := v - 1; k >= 0; k-- {
+				x := int(I[j+k]) + int(B[k]) + carry
+				I[j+k], carry = byte(x), x>>8
+			}
+		}
+	}
+	return out[:size]
+}
+
+// bmpString encodes s as big-endian UTF-16.
+func bmpString(s string) []byte {
+	u := utf16.Encode([]rune(s))
+	b := make([]byte, 0, 2*len(u)+2)
+	for _, r := range u {
+		b = append(b, byte(r>>8), byte(r))
+	}
+	return b
+}
+
+func randomBytes(r io.Reader, n int) ([]byte, error) {
+	b := make([]byte, n)
+	_, err := io.ReadFull(r, b)
+	return b, err
+}
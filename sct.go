@@ -0,0 +1,91 @@
+package zcert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Certificate Transparency embedded SCT list extension (RFC 6962, 3.3).
+var oidSCTList = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+
+// DummySCTExtension creates a Certificate Transparency SCT list extension with
+// n signed certificate timestamps (RFC 6962), for testing clients that require
+// SCTs to be present.
+//
+// WARNING: the SCTs are from a made-up log, with a random key that's thrown
+// away, and the signatures are over random data. They're well-formed but will
+// never verify, so this is only useful for conformance and negative testing.
+func DummySCTExtension(n int) (pkix.Extension, error) {
+	return dummySCTExtension(rand.Reader, time.Now(), n)
+}
+
+// dummySCTExtension creates the extension with randomness from r, and now as
+// the timestamp.
+func dummySCTExtension(r io.Reader, now time.Time, n int) (pkix.Extension, error) {
+	if n < 1 {
+		return pkix.Extension{}, errors.New("zcert.DummySCTExtension: need at least one SCT")
+	}
+
+	var list []byte
+	for i := 0; i < n; i++ {
+		sct, err := dummySCT(r, now)
+		if err != nil {
+			return pkix.Extension{}, fmt.Errorf("zcert.DummySCTExtension: %w", err)
+		}
+		list = appendUint16(list, len(sct), sct...)
+	}
+
+	// The extension value is an OCTET STRING with the TLS-encoded
+	// SignedCertificateTimestampList.
+	v, err := asn1.Marshal(appendUint16(nil, len(list), list...))
+	if err != nil {
+		return pkix.Extension{}, fmt.Errorf("zcert.DummySCTExtension: %w", err)
+	}
+	return pkix.Extension{Id: oidSCTList, Value: v}, nil
+}
+
+// dummySCT creates a single TLS-encoded v1 SCT.
+func dummySCT(r io.Reader, now time.Time) ([]byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), r)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	logID := sha256.Sum256(pub)
+
+	data, err := randomBytes(r, 32)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := key.Sign(r, data, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	sct := []byte{0} // Version: v1
+	sct = append(sct, logID[:]...)
+	sct = append(sct, make([]byte, 8)...)
+	binary.BigEndian.PutUint64(sct[len(sct)-8:], uint64(now.UnixNano()/int64(time.Millisecond)))
+	sct = appendUint16(sct, 0) // No extensions.
+	sct = append(sct, 4, 3)    // SHA-256, ECDSA
+	sct = appendUint16(sct, len(sig), sig...)
+	return sct, nil
+}
+
+// appendUint16 appends n as a big-endian uint16, followed by data.
+func appendUint16(b []byte, n int, data ...byte) []byte {
+	return append(append(b, byte(n>>8), byte(n)), data...)
+}
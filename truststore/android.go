@@ -0,0 +1,147 @@
+package truststore
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Android installs to the system store of an Android device or emulator with
+// adb. The device is selected with adb's ANDROID_SERIAL environment variable
+// if more than one is connected.
+//
+// This needs "adb root" and a writable system partition, which means an
+// emulator image without the Play Store started with "emulator
+// -writable-system", or a userdebug build with verity disabled. Android 14
+// and newer load the system certificates from the conscrypt APEX, which isn't
+// supported.
+type Android struct {
+	verbose bool
+}
+
+// Directory with the system CA certificates on Android.
+const androidCACerts = "/system/etc/security/cacerts"
+
+func (Android) Name() string      { return "Android" }
+func (t *Android) Verbose(v bool) { t.verbose = v }
+
+func (Android) androidPath(caCert *x509.Certificate) string {
+	return androidCACerts + "/" + subjectHashOld(caCert) + ".0"
+}
+
+// OnSystem reports if adb is in PATH and a device is connected.
+func (Android) OnSystem() bool {
+	if !binaryExists("adb") {
+		return false
+	}
+	out, err := exec.Command("adb", "get-state").Output()
+	return err == nil && strings.TrimSpace(string(out)) == "device"
+}
+
+func (t Android) HasCert(caCert *x509.Certificate) bool {
+	c, err := t.FindInstalled(caCert)
+	return err == nil && c != nil && bytes.Equal(c.Raw, caCert.Raw)
+}
+
+func (t Android) FindInstalled(caCert *x509.Certificate) (*x509.Certificate, error) {
+	out, err := exec.Command("adb", "shell", "cat", t.androidPath(caCert)).CombinedOutput()
+	if bytes.Contains(out, []byte("No such file")) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("truststore.Android: %w: %s", err, out)
+	}
+	c, err := parseCert(out)
+	if err != nil {
+		return nil, fmt.Errorf("truststore.Android: %w", err)
+	}
+	return c, nil
+}
+
+func (t Android) Install(rootCert string, caCert *x509.Certificate) error {
+	return t.InstallContext(context.Background(), rootCert, caCert)
+}
+
+func (t Android) InstallContext(ctx context.Context, rootCert string, caCert *x509.Certificate) error {
+	c, err := t.FindInstalled(caCert)
+	if err != nil {
+		return err
+	}
+	if c != nil {
+		if bytes.Equal(c.Raw, caCert.Raw) {
+			return nil
+		}
+		return fmt.Errorf("truststore.Android: %q already exists with a different certificate", t.androidPath(caCert))
+	}
+
+	err = t.remount(ctx)
+	if err != nil {
+		return err
+	}
+	if t.verbose {
+		Log.Printf("truststore.Android: writing to %q", t.androidPath(caCert))
+	}
+	if _, err := t.adb(ctx, "push", rootCert, t.androidPath(caCert)); err != nil {
+		return err
+	}
+	_, err = t.adb(ctx, "shell", "chmod", "644", t.androidPath(caCert))
+	return err
+}
+
+func (t Android) Uninstall(rootCert string, caCert *x509.Certificate) error {
+	return t.UninstallContext(context.Background(), rootCert, caCert)
+}
+
+func (t Android) UninstallContext(ctx context.Context, rootCert string, caCert *x509.Certificate) error {
+	if !t.HasCert(caCert) {
+		return nil
+	}
+
+	err := t.remount(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = t.adb(ctx, "shell", "rm", "-f", t.androidPath(caCert))
+	return err
+}
+
+// remount restarts adbd as root and remounts the system partition as
+// writable.
+func (t Android) remount(ctx context.Context) error {
+	out, err := t.adb(ctx, "root")
+	if err != nil {
+		return err
+	}
+	if bytes.Contains(out, []byte("cannot run as root")) {
+		return fmt.Errorf("truststore.Android: adb root: %s", bytes.TrimSpace(out))
+	}
+
+	// adbd restarts after "adb root", so wait until it's back.
+	if _, err := t.adb(ctx, "wait-for-device"); err != nil {
+		return err
+	}
+	_, err = t.adb(ctx, "remount")
+	return err
+}
+
+func (t Android) adb(ctx context.Context, args ...string) ([]byte, error) {
+	out, err := exec.CommandContext(ctx, "adb", args...).CombinedOutput()
+	if err != nil {
+		return out, fmt.Errorf("truststore.Android: adb %s: %w: %s",
+			strings.Join(args, " "), err, bytes.TrimSpace(out))
+	}
+	return out, nil
+}
+
+// subjectHashOld gets the hash that Android uses as the filename; this is the
+// same as "openssl x509 -subject_hash_old": the first four bytes of the MD5
+// of the subject, as a little-endian hex number.
+func subjectHashOld(caCert *x509.Certificate) string {
+	h := md5.Sum(caCert.RawSubject)
+	return fmt.Sprintf("%08x", binary.LittleEndian.Uint32(h[:4]))
+}
@@ -2,6 +2,7 @@ package truststore
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha1"
 	"crypto/sha256"
 	"crypto/x509"
@@ -41,16 +42,37 @@ var (
 
 		return ""
 	}()
-
-	storePass = "changeit"
 )
 
-type Java struct{ verbose bool }
+type Java struct {
+	verbose bool
+
+	// Keystore file to use; the default is the JDK's cacerts. This can be a
+	// JKS or PKCS#12 file, and is created if it doesn't exist.
+	Keystore string
+
+	// Password for the keystore; the default is "changeit".
+	StorePass string
+}
 
 func (Java) Name() string      { return "Java" }
 func (t *Java) Verbose(v bool) { t.verbose = v }
 func (Java) OnSystem() bool    { return hasKeytool }
 
+func (t Java) keystore() string {
+	if t.Keystore != "" {
+		return t.Keystore
+	}
+	return cacertsPath
+}
+
+func (t Java) storePass() string {
+	if t.StorePass != "" {
+		return t.StorePass
+	}
+	return "changeit"
+}
+
 func (t Java) HasCert(caCert *x509.Certificate) bool {
 	if !hasKeytool {
 		return false
@@ -65,7 +87,7 @@ func (t Java) HasCert(caCert *x509.Certificate) bool {
 	}
 
 	keytoolOutput, err := exec.Command(keytoolPath, "-list", "-keystore",
-		cacertsPath, "-storepass", storePass).CombinedOutput()
+		t.keystore(), "-storepass", t.storePass()).CombinedOutput()
 	if err != nil {
 		// fatalIfCmdErr(err, "keytool -list", keytoolOutput)
 		return false
@@ -80,13 +102,38 @@ func (t Java) HasCert(caCert *x509.Certificate) bool {
 	return exists(caCert, s1, keytoolOutput) || exists(caCert, s256, keytoolOutput)
 }
 
+func (t Java) FindInstalled(caCert *x509.Certificate) (*x509.Certificate, error) {
+	if !hasKeytool {
+		return nil, nil
+	}
+	out, err := exec.Command(keytoolPath, "-exportcert", "-rfc",
+		"-alias", CAName(caCert),
+		"-keystore", t.keystore(),
+		"-storepass", t.storePass()).CombinedOutput()
+	if bytes.Contains(out, []byte("does not exist")) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("truststore.Java: %w: %s", err, out)
+	}
+	c, err := parseCert(out)
+	if err != nil {
+		return nil, fmt.Errorf("truststore.Java: %w", err)
+	}
+	return c, nil
+}
+
 func (t Java) Install(rootCert string, caCert *x509.Certificate) error {
-	_, err := t.execKeytool(exec.Command(keytoolPath,
+	return t.InstallContext(context.Background(), rootCert, caCert)
+}
+
+func (t Java) InstallContext(ctx context.Context, rootCert string, caCert *x509.Certificate) error {
+	_, err := t.execKeytool(ctx, exec.CommandContext(ctx, keytoolPath,
 		"-importcert", "-noprompt",
-		"-keystore", cacertsPath,
-		"-storepass", storePass,
+		"-keystore", t.keystore(),
+		"-storepass", t.storePass(),
 		"-file", rootCert,
-		"-alias", caName(caCert)))
+		"-alias", CAName(caCert)))
 	if err != nil {
 		return err
 	}
@@ -94,11 +141,15 @@ func (t Java) Install(rootCert string, caCert *x509.Certificate) error {
 }
 
 func (t Java) Uninstall(rootCert string, caCert *x509.Certificate) error {
-	out, err := t.execKeytool(exec.Command(keytoolPath,
+	return t.UninstallContext(context.Background(), rootCert, caCert)
+}
+
+func (t Java) UninstallContext(ctx context.Context, rootCert string, caCert *x509.Certificate) error {
+	out, err := t.execKeytool(ctx, exec.CommandContext(ctx, keytoolPath,
 		"-delete",
-		"-alias", caName(caCert),
-		"-keystore", cacertsPath,
-		"-storepass", storePass))
+		"-alias", CAName(caCert),
+		"-keystore", t.keystore(),
+		"-storepass", t.storePass()))
 	if bytes.Contains(out, []byte("does not exist")) {
 		return nil
 	}
@@ -110,14 +161,14 @@ func (t Java) Uninstall(rootCert string, caCert *x509.Certificate) error {
 
 // execKeytool will execute a "keytool" command and if needed re-execute
 // the command with privCmd to work around file permissions.
-func (t Java) execKeytool(cmd *exec.Cmd) ([]byte, error) {
+func (t Java) execKeytool(ctx context.Context, cmd *exec.Cmd) ([]byte, error) {
 	out, err := cmd.CombinedOutput()
 	if err != nil && bytes.Contains(out, []byte("java.io.FileNotFoundException")) && runtime.GOOS != "windows" {
 		origArgs := cmd.Args[1:]
-		cmd = privCmd(cmd.Path)
+		cmd = privCmd(ctx, cmd.Path)
 		cmd.Args = append(cmd.Args, origArgs...)
 		cmd.Env = []string{"JAVA_HOME=" + javaHome}
-		out, err = cmd.CombinedOutput()
+		out, err = privOutput(cmd)
 	}
 
 	if err != nil {
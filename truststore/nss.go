@@ -2,13 +2,18 @@ package truststore
 
 import (
 	"bytes"
+	"context"
 	"crypto/x509"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strings"
+	"sync"
+	"time"
 )
 
 var (
@@ -22,6 +27,9 @@ var (
 		"/usr/bin/firefox",
 		"/usr/bin/firefox-nightly",
 		"/usr/bin/firefox-developer-edition",
+		"/snap/bin/firefox",                        // Snap
+		"/var/lib/flatpak/app/org.mozilla.firefox", // Flatpak, system-wide
+		filepath.Join(os.Getenv("HOME"), ".local/share/flatpak/app/org.mozilla.firefox"), // Flatpak, user
 		"/Applications/Firefox.app",
 		"/Applications/FirefoxDeveloperEdition.app",
 		"/Applications/Firefox Developer Edition.app",
@@ -30,13 +38,41 @@ var (
 	}
 )
 
-type NSS struct{ verbose bool }
+// NSSProfiles selects which NSS databases to use.
+type NSSProfiles int
+
+const (
+	NSSAll     NSSProfiles = iota // Shared databases and Firefox profiles.
+	NSSShared                     // Only shared databases such as ~/.pki/nssdb, which Chrome uses on Linux.
+	NSSFirefox                    // Only Firefox profiles.
+)
+
+type NSS struct {
+	verbose bool
+
+	// Which databases to use; the default is to use all of them.
+	Profiles NSSProfiles
+
+	// Retry certutil this many times if the database is locked (e.g. because
+	// a browser has it open), waiting RetryWait times the attempt number
+	// between tries. Defaults to 3 times and 1 second; set Retries to -1 to
+	// disable.
+	Retries   int
+	RetryWait time.Duration
+}
 
 func (NSS) Name() string      { return "NSS" }
 func (t *NSS) Verbose(v bool) { t.verbose = v }
 
-func (NSS) OnSystem() bool {
-	for _, p := range append(nssDBs, firefoxPaths...) {
+func (t NSS) OnSystem() bool {
+	var paths []string
+	if t.Profiles != NSSFirefox {
+		paths = append(paths, nssDBs...)
+	}
+	if t.Profiles != NSSShared {
+		paths = append(paths, firefoxPaths...)
+	}
+	for _, p := range paths {
 		if pathExists(p) {
 			return true
 		}
@@ -46,16 +82,48 @@ func (NSS) OnSystem() bool {
 
 func (t NSS) HasCert(caCert *x509.Certificate) bool {
 	p, err := t.forEachProfile(func(profile string) error {
-		return exec.Command("certutil", "-V", "-d", profile, "-u", "L", "-n", caName(caCert)).Run()
+		return exec.Command("certutil", "-V", "-d", profile, "-u", "L", "-n", CAName(caCert)).Run()
 	})
 	return err == nil && p > 0
 }
 
+func (t NSS) FindInstalled(caCert *x509.Certificate) (*x509.Certificate, error) {
+	var found *x509.Certificate
+	_, err := t.forEachProfile(func(profile string) error {
+		if found != nil {
+			return nil
+		}
+		out, err := exec.Command("certutil", "-L", "-a", "-d", profile, "-n", CAName(caCert)).Output()
+		if err != nil { // Not in this profile.
+			return nil
+		}
+		found, err = parseCert(out)
+		if err != nil {
+			return fmt.Errorf("truststore.NSS: %s: %w", profile, err)
+		}
+		return nil
+	})
+	return found, err
+}
+
 func (t NSS) Install(rootCert string, caCert *x509.Certificate) error {
+	return t.InstallContext(context.Background(), rootCert, caCert)
+}
+
+func (t NSS) InstallContext(ctx context.Context, rootCert string, caCert *x509.Certificate) error {
+	if !binaryExists("certutil") {
+		help := "install the NSS tools"
+		if certutilInstallHelp != "" {
+			help = fmt.Sprintf("install it with %q", certutilInstallHelp)
+		}
+		return fmt.Errorf("truststore.NSS: certutil not found; %s to install to %s, or skip NSS with TRUST_STORES",
+			help, nssBrowsers)
+	}
+
 	p, err := t.forEachProfile(func(profile string) error {
-		out, err := t.execCertutil(exec.Command("certutil",
+		out, err := t.execCertutil(ctx, exec.CommandContext(ctx, "certutil",
 			"-A", "-d", profile, "-t", "C,,", "-n",
-			caName(caCert), "-i", rootCert))
+			CAName(caCert), "-i", rootCert))
 		if err != nil {
 			return fmt.Errorf("certutil -A -d %s: %s", profile, out)
 		}
@@ -68,20 +136,34 @@ func (t NSS) Install(rootCert string, caCert *x509.Certificate) error {
 		return errors.New("truststore.NSS: no security database found")
 	}
 
-	if !t.HasCert(caCert) {
-		return fmt.Errorf("truststore.NSS: installing to %q failed", "TODO")
+	var processed, failed []string
+	t.forEachProfile(func(profile string) error {
+		processed = append(processed, fmt.Sprintf("%q", profile))
+		err := exec.CommandContext(ctx, "certutil", "-V", "-d", profile, "-u", "L", "-n", CAName(caCert)).Run()
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%q", profile))
+		}
+		return nil
+	})
+	if len(failed) > 0 {
+		return fmt.Errorf("truststore.NSS: certificate not found after installing to %s (installed to %s)",
+			strings.Join(failed, ", "), strings.Join(processed, ", "))
 	}
 	return nil
 }
 
 func (t NSS) Uninstall(rootCert string, caCert *x509.Certificate) error {
+	return t.UninstallContext(context.Background(), rootCert, caCert)
+}
+
+func (t NSS) UninstallContext(ctx context.Context, rootCert string, caCert *x509.Certificate) error {
 	_, err := t.forEachProfile(func(profile string) error {
-		err := exec.Command("certutil", "-V", "-d", profile, "-u", "L", "-n", caName(caCert)).Run()
+		err := exec.CommandContext(ctx, "certutil", "-V", "-d", profile, "-u", "L", "-n", CAName(caCert)).Run()
 		if err != nil {
-			return nil
+			return ctx.Err() // nil if it's just not in this profile.
 		}
 
-		out, err := t.execCertutil(exec.Command("certutil", "-D", "-d", profile, "-n", caName(caCert)))
+		out, err := t.execCertutil(ctx, exec.CommandContext(ctx, "certutil", "-D", "-d", profile, "-n", CAName(caCert)))
 		if err != nil {
 			return fmt.Errorf("certutil -D -d %s: %s", profile, out)
 		}
@@ -90,9 +172,17 @@ func (t NSS) Uninstall(rootCert string, caCert *x509.Certificate) error {
 	return err
 }
 
-func (NSS) forEachProfile(f func(profile string) error) (int, error) {
-	profiles, _ := filepath.Glob(firefoxProfile)
-	profiles = append(profiles, nssDBs...)
+func (t NSS) forEachProfile(f func(profile string) error) (int, error) {
+	var profiles []string
+	if t.Profiles != NSSShared {
+		for _, g := range firefoxProfiles {
+			p, _ := filepath.Glob(g)
+			profiles = append(profiles, p...)
+		}
+	}
+	if t.Profiles != NSSFirefox {
+		profiles = append(profiles, nssDBs...)
+	}
 
 	var found int
 	for _, profile := range profiles {
@@ -101,7 +191,7 @@ func (NSS) forEachProfile(f func(profile string) error) (int, error) {
 		}
 
 		var err error
-		if pathExists(filepath.Join(profile, "cert9.db")) {
+		if pathExists(filepath.Join(profile, "cert9.db")) && certutilSQL() {
 			err = f("sql:" + profile)
 			found++
 		} else if pathExists(filepath.Join(profile, "cert8.db")) {
@@ -115,17 +205,100 @@ func (NSS) forEachProfile(f func(profile string) error) (int, error) {
 	return found, nil
 }
 
+var (
+	certutilSQLOnce sync.Once
+	certutilSQLOK   bool
+)
+
+// certutilSQL reports if certutil understands the "sql:" prefix for cert9.db
+// databases. Very old versions don't, and silently use a directory called
+// "sql:..." instead; in that case only cert8.db databases are used.
+//
+// This is checked once by creating a new database in a temporary directory.
+func certutilSQL() bool {
+	certutilSQLOnce.Do(func() {
+		// Can't check; just assume it works and let the actual certutil
+		// commands report any errors.
+		certutilSQLOK = true
+		if !binaryExists("certutil") {
+			return
+		}
+		tmp, err := ioutil.TempDir("", "zcert-certutil-")
+		if err != nil {
+			return
+		}
+		defer os.RemoveAll(tmp)
+
+		out, err := exec.Command("certutil", "-N", "-d", "sql:"+tmp, "--empty-password").CombinedOutput()
+		certutilSQLOK = err == nil && pathExists(filepath.Join(tmp, "cert9.db"))
+		if !certutilSQLOK {
+			Log.Printf("truststore.NSS: certutil doesn't seem to support cert9.db (\"sql:\") databases; "+
+				"only cert8.db databases will be used. Upgrade the NSS tools to fix this.\n%s", out)
+		}
+	})
+	return certutilSQLOK
+}
+
 // execCertutil will execute a "certutil" command and if needed re-execute
 // the command with privCmd to work around file permissions.
-func (NSS) execCertutil(cmd *exec.Cmd) ([]byte, error) {
-	out, err := cmd.CombinedOutput()
+//
+// It will retry the command if the database is locked, until ctx is cancelled.
+func (t NSS) execCertutil(ctx context.Context, cmd *exec.Cmd) ([]byte, error) {
+	var (
+		path, args = cmd.Path, cmd.Args[1:]
+		priv       bool
+		retries    = t.Retries
+		wait       = t.RetryWait
+	)
+	if retries == 0 {
+		retries = 3
+	}
+	if wait == 0 {
+		wait = time.Second
+	}
+
+	for i := 0; ; i++ {
+		run := (*exec.Cmd).CombinedOutput
+		if priv {
+			run = privOutput
+		}
+		out, err := run(cmd)
+		if err != nil && !priv && bytes.Contains(out, []byte("SEC_ERROR_READ_ONLY")) && runtime.GOOS != "windows" {
+			priv = true
+			cmd = privCmd(ctx, path)
+			cmd.Args = append(cmd.Args, args...)
+			out, err = privOutput(cmd)
+		}
+		if err == nil || i >= retries || !dbLocked(out) {
+			return out, err
+		}
 
-	if err != nil && bytes.Contains(out, []byte("SEC_ERROR_READ_ONLY")) && runtime.GOOS != "windows" {
-		origArgs := cmd.Args[1:]
-		cmd = privCmd(cmd.Path)
-		cmd.Args = append(cmd.Args, origArgs...)
-		out, err = cmd.CombinedOutput()
+		if t.verbose {
+			Log.Printf("truststore.NSS: database locked; retrying in %s (attempt %d of %d)", wait*time.Duration(i+1), i+1, retries)
+		}
+		select {
+		case <-ctx.Done():
+			return out, ctx.Err()
+		case <-time.After(wait * time.Duration(i+1)):
+		}
+
+		// An exec.Cmd can't be re-used.
+		if priv {
+			cmd = privCmd(ctx, path)
+			cmd.Args = append(cmd.Args, args...)
+		} else {
+			cmd = exec.CommandContext(ctx, path, args...)
+		}
 	}
+}
 
-	return out, err
+// dbLocked reports if the certutil output indicates the database is in use by
+// another process.
+func dbLocked(out []byte) bool {
+	for _, e := range []string{"SEC_ERROR_LOCKED", "database is locked"} {
+		if bytes.Contains(out, []byte(e)) {
+			return true
+		}
+	}
+	return false
 }
@@ -1,14 +1,24 @@
 package truststore
 
 import (
+	"context"
 	"crypto/x509"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"log"
 	"os"
 	"os/exec"
 	"os/user"
+	"strings"
 	"sync"
+	"time"
 )
 
+// Log is used for any informational messages, such as warnings and the output
+// of failed commands. Use Log.SetOutput(ioutil.Discard) to silence it.
+var Log = log.New(os.Stderr, "zcert: ", 0)
+
 type Store interface {
 	Name() string                                              // Name for this truststore.
 	OnSystem() bool                                            // Is this trust store on the system?
@@ -18,31 +28,253 @@ type Store interface {
 	Uninstall(rootCert string, cacert *x509.Certificate) error // Uninstall existing certificate.
 }
 
+// Finder is an optional interface for stores which can get the installed
+// certificate, for example to check if it's identical to the root certificate
+// on disk.
+type Finder interface {
+	// FindInstalled gets the installed certificate for caCert; it returns nil
+	// if it's not installed.
+	FindInstalled(caCert *x509.Certificate) (*x509.Certificate, error)
+}
+
+// ErrNotSupported is returned by FindInstalled() if the store doesn't
+// implement Finder.
+var ErrNotSupported = errors.New("truststore: not supported by this store")
+
+// FindInstalled gets the installed certificate for caCert from the store, or
+// nil if it's not installed.
+//
+// This returns ErrNotSupported if the store doesn't implement Finder.
+func FindInstalled(s Store, caCert *x509.Certificate) (*x509.Certificate, error) {
+	f, ok := s.(Finder)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	return f.FindInstalled(caCert)
+}
+
+// ContextStore is an optional interface for stores which can cancel Install()
+// and Uninstall() with a context, for example to stop waiting for a sudo
+// password prompt.
+type ContextStore interface {
+	InstallContext(ctx context.Context, rootCert string, caCert *x509.Certificate) error
+	UninstallContext(ctx context.Context, rootCert string, caCert *x509.Certificate) error
+}
+
+// InstallContext installs caCert to the store, stopping when ctx is cancelled.
+//
+// If the store doesn't implement ContextStore it will call Install(), which
+// keeps running until it's done; ctx is only checked before starting.
+func InstallContext(ctx context.Context, s Store, rootCert string, caCert *x509.Certificate) error {
+	if cs, ok := s.(ContextStore); ok {
+		return cs.InstallContext(ctx, rootCert, caCert)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.Install(rootCert, caCert)
+}
+
+// UninstallContext uninstalls caCert from the store, stopping when ctx is
+// cancelled.
+//
+// If the store doesn't implement ContextStore it will call Uninstall(), which
+// keeps running until it's done; ctx is only checked before starting.
+func UninstallContext(ctx context.Context, s Store, rootCert string, caCert *x509.Certificate) error {
+	if cs, ok := s.(ContextStore); ok {
+		return cs.UninstallContext(ctx, rootCert, caCert)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.Uninstall(rootCert, caCert)
+}
+
+var (
+	registeredMu sync.Mutex
+	registered   []Store
+)
+
+// Register a new Store, in addition to the built-in ones.
+//
+// Registered stores are returned by Find() if they're OnSystem(), after the
+// built-in ones.
+func Register(s Store) {
+	registeredMu.Lock()
+	defer registeredMu.Unlock()
+	registered = append(registered, s)
+}
+
+// Options for FindOpts.
+type Options struct {
+	Verbose bool // Set Verbose() on the returned stores.
+
+	// Also use the stores of the current user where this is supported, in
+	// addition to the system-wide ones. Currently this is just the macOS login
+	// keychain.
+	User bool
+
+	// Retry NSS's certutil this many times if the database is locked, waiting
+	// NSSRetryWait times the attempt number between tries. Defaults to 3
+	// times and 1 second; set NSSRetries to -1 to disable.
+	NSSRetries   int
+	NSSRetryWait time.Duration
+
+	// Which NSS databases to use; the default is to use both the shared
+	// databases and all Firefox profiles.
+	NSSProfiles NSSProfiles
+
+	// Keystore file and password for the Java store, instead of the JDK's
+	// cacerts with the default password.
+	JavaKeystore  string
+	JavaStorePass string
+
+	// Only use these stores; this overrides the TRUST_STORES environment
+	// variable. See ParseStores for the names.
+	Stores []string
+}
+
 // Find all stores enabled on this system.
 //
 // If verbose is given the Verbose() will be set on the returned stores.
 func Find(verbose bool) []Store {
-	var storeEnabled map[string]bool
-	// TODO: use flag for this.
-	// if ts := os.Getenv("TRUST_STORES"); ts != "" {
-	// 	storeEnabled = make(map[string]bool)
-	// 	for _, store := range strings.Split(ts, ",") {
-	// 		storeEnabled[strings.TrimSpace(store)] = true
-	// 	}
-	// }
+	return FindOpts(Options{Verbose: verbose})
+}
+
+// FindOpts is like Find, but allows setting more options.
+//
+// Only the stores listed in the TRUST_STORES environment variable are returned
+// if it's set; unknown names are ignored. Use FindStores to get an error
+// instead.
+func FindOpts(opts Options) []Store {
+	stores, _ := FindStores(opts)
+	return stores
+}
+
+// FindStores is like FindOpts, but returns an error if Options.Stores or
+// TRUST_STORES contains unknown store names.
+func FindStores(opts Options) ([]Store, error) {
+	all := []Store{&NSS{Retries: opts.NSSRetries, RetryWait: opts.NSSRetryWait, Profiles: opts.NSSProfiles}, &Java{Keystore: opts.JavaKeystore, StorePass: opts.JavaStorePass}, &Unix{}, &Darwin{User: opts.User}, &Windows{}, &Android{}}
+	registeredMu.Lock()
+	all = append(all, registered...)
+	registeredMu.Unlock()
+
+	var (
+		storeEnabled map[string]bool
+		err          error
+	)
+	if opts.Stores != nil {
+		var names []string
+		names, err = parseStores(all, strings.Join(opts.Stores, ","))
+		storeEnabled = make(map[string]bool)
+		for _, n := range names {
+			storeEnabled[n] = true
+		}
+	} else if ts := os.Getenv("TRUST_STORES"); ts != "" {
+		var names []string
+		names, err = parseStores(all, ts)
+		storeEnabled = make(map[string]bool)
+		for _, n := range names {
+			storeEnabled[n] = true
+		}
+		if err != nil {
+			err = fmt.Errorf("TRUST_STORES: %w", err)
+		}
+	}
 
 	var stores []Store
-	for _, t := range []Store{&NSS{}, &Java{}, &Unix{}, &Darwin{}, &Windows{}} {
+	for _, t := range all {
 		if t.OnSystem() && (storeEnabled == nil || storeEnabled[t.Name()]) {
-			t.Verbose(verbose)
+			t.Verbose(opts.Verbose)
 			stores = append(stores, t)
 		}
 	}
-	return stores
+	return stores, err
 }
 
-func caName(caCert *x509.Certificate) string {
-	return "zcert development CA " + caCert.SerialNumber.String()
+// ParseStores parses a comma-separated list of store names, as used in the
+// TRUST_STORES environment variable.
+//
+// Names are matched case-insensitively against the Name() of all stores,
+// including registered ones, and "system" is an alias for the operating
+// system's store (Unix, Darwin, or Windows). The returned names are the
+// Name() of the stores.
+//
+// An error is returned for unknown names; all valid names are still returned.
+func ParseStores(s string) ([]string, error) {
+	registeredMu.Lock()
+	all := append([]Store{&NSS{}, &Java{}, &Unix{}, &Darwin{}, &Windows{}, &Android{}}, registered...)
+	registeredMu.Unlock()
+	return parseStores(all, s)
+}
+
+func parseStores(all []Store, s string) ([]string, error) {
+	var (
+		names   []string
+		unknown []string
+	)
+	for _, n := range strings.Split(s, ",") {
+		n = strings.TrimSpace(n)
+		if n == "" {
+			continue
+		}
+		if strings.EqualFold(n, "system") {
+			names = append(names, "Unix", "Darwin", "Windows")
+			continue
+		}
+		found := false
+		for _, t := range all {
+			if strings.EqualFold(t.Name(), n) {
+				names = append(names, t.Name())
+				found = true
+				break
+			}
+		}
+		if !found {
+			unknown = append(unknown, fmt.Sprintf("%q", n))
+		}
+	}
+
+	valid := func() string {
+		v := make([]string, 0, len(all)+1)
+		for _, t := range all {
+			v = append(v, t.Name())
+		}
+		return strings.Join(append(v, "system"), ", ")
+	}
+	if len(unknown) > 0 {
+		return names, fmt.Errorf("unknown trust store: %s; valid names are: %s",
+			strings.Join(unknown, ", "), valid())
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no trust stores given; valid names are: %s", valid())
+	}
+	return names, nil
+}
+
+// CAName gets the name of the root certificate in trust stores; this is the
+// Organization and serial number, so it's the same for install and uninstall
+// as long as the certificate is the same.
+func CAName(caCert *x509.Certificate) string {
+	org := "zcert development CA"
+	if len(caCert.Subject.Organization) > 0 && caCert.Subject.Organization[0] != "" {
+		org = caCert.Subject.Organization[0]
+	}
+	return org + " " + caCert.SerialNumber.String()
+}
+
+// parseCert parses the first PEM-encoded certificate in data.
+func parseCert(data []byte) (*x509.Certificate, error) {
+	for {
+		var b *pem.Block
+		b, data = pem.Decode(data)
+		if b == nil {
+			return nil, errors.New("no certificate found")
+		}
+		if b.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(b.Bytes)
+		}
+	}
 }
 
 func pathExists(path string) bool {
@@ -55,22 +287,38 @@ func binaryExists(name string) bool {
 	return err == nil
 }
 
-var privWarning sync.Once
+var (
+	privWarning sync.Once
+
+	// Stores may be installed concurrently, but only one command should ask
+	// for a password at a time.
+	privMu sync.Mutex
+)
+
+// privOutput runs a command from privCmd() with CombinedOutput(); only one
+// command runs at a time, so sudo and doas don't ask for a password several
+// times at once.
+func privOutput(cmd *exec.Cmd) ([]byte, error) {
+	privMu.Lock()
+	defer privMu.Unlock()
+	return cmd.CombinedOutput()
+}
 
-func privCmd(cmd ...string) *exec.Cmd {
+// privCmd creates a command that runs as root, with sudo or doas if needed.
+// The command is killed if ctx is cancelled. Run it with privOutput().
+func privCmd(ctx context.Context, cmd ...string) *exec.Cmd {
 	if u, err := user.Current(); err == nil && u.Uid == "0" {
-		return exec.Command(cmd[0], cmd[1:]...)
+		return exec.CommandContext(ctx, cmd[0], cmd[1:]...)
 	}
 	if binaryExists("sudo") {
-		return exec.Command("sudo", append([]string{"--prompt=Sudo password:", "--"}, cmd...)...)
+		return exec.CommandContext(ctx, "sudo", append([]string{"--prompt=Sudo password:", "--"}, cmd...)...)
 	}
 	if binaryExists("doas") {
-		return exec.Command("doas", append([]string{"--"}, cmd...)...)
+		return exec.CommandContext(ctx, "doas", append([]string{"--"}, cmd...)...)
 	}
 
 	privWarning.Do(func() {
-		fmt.Fprintf(os.Stderr,
-			"zcert: sudo or doas not available and not running as root; the (un)install might fail")
+		Log.Print("sudo or doas not available and not running as root; the (un)install might fail")
 	})
-	return exec.Command(cmd[0], cmd[1:]...)
+	return exec.CommandContext(ctx, cmd[0], cmd[1:]...)
 }
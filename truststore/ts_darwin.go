@@ -4,22 +4,26 @@ package truststore
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha1"
 	"crypto/x509"
 	"encoding/asn1"
+	"encoding/pem"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"runtime"
 
 	"howett.net/plist"
 )
 
 var (
-	firefoxProfile = os.Getenv("HOME") + "/Library/Application Support/Firefox/Profiles/*"
-	nssBrowsers    = "Firefox"
-
-	CertutilInstallHelp = "brew install nss"
+	firefoxProfiles     = []string{os.Getenv("HOME") + "/Library/Application Support/Firefox/Profiles/*"}
+	nssBrowsers         = "Firefox"
+	certutilInstallHelp = "brew install nss"
 )
 
 // https://github.com/golang/go/issues/24652#issuecomment-399826583
@@ -50,21 +54,89 @@ var trustSettingsData = []byte(`
 </array>
 `)
 
-type Darwin struct{ verbose bool }
+type Darwin struct {
+	verbose bool
+
+	// Also add the certificate to the login keychain of the current user; this
+	// doesn't require admin permissions.
+	User bool
+}
 
 func (Darwin) Name() string      { return "Darwin" }
 func (t *Darwin) Verbose(v bool) { t.verbose = v }
 func (Darwin) OnSystem() bool    { return runtime.GOOS == "darwin" }
 
 func (t Darwin) HasCert(caCert *x509.Certificate) bool {
-	// TODO
-	return false
+	if !inKeychain(systemKeychain, caCert) || !isTrusted(caCert) {
+		return false
+	}
+	return !t.User || inKeychain(loginKeychain(), caCert)
+}
+
+const systemKeychain = "/Library/Keychains/System.keychain"
+
+// inKeychain reports if caCert is in the keychain.
+func inKeychain(keychain string, caCert *x509.Certificate) bool {
+	args := []string{"find-certificate", "-a", "-p"}
+	if caCert.Subject.CommonName != "" {
+		args = append(args, "-c", caCert.Subject.CommonName)
+	}
+	out, err := exec.Command("security", append(args, keychain)...).Output()
+	if err != nil {
+		return false
+	}
+	for {
+		var b *pem.Block
+		b, out = pem.Decode(out)
+		if b == nil {
+			return false
+		}
+		if b.Type == "CERTIFICATE" && bytes.Equal(b.Bytes, caCert.Raw) {
+			return true
+		}
+	}
+}
+
+// isTrusted reports if caCert is trusted as a root for TLS; a certificate can
+// be in a keychain without being trusted, for example if the trust settings
+// were removed or set to "Never Trust".
+func isTrusted(caCert *x509.Certificate) bool {
+	fp, err := ioutil.TempFile("", "zcert-verify")
+	if err != nil {
+		return false
+	}
+	defer os.Remove(fp.Name())
+	err = pem.Encode(fp, &pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw})
+	if err != nil {
+		fp.Close()
+		return false
+	}
+	if err := fp.Close(); err != nil {
+		return false
+	}
+
+	// -l: the "leaf" is a CA, -L: don't fetch anything from the network.
+	err = exec.Command("security", "verify-cert", "-c", fp.Name(), "-p", "ssl", "-l", "-L", "-q").Run()
+	return err == nil
 }
 
 func (t Darwin) Install(rootCert string, caCert *x509.Certificate) error {
-	cmd := privCmd("security", "add-trusted-cert", "-d", "-k",
-		"/Library/Keychains/System.keychain", rootCert)
-	_, err := cmd.CombinedOutput()
+	return t.InstallContext(context.Background(), rootCert, caCert)
+}
+
+func (t Darwin) InstallContext(ctx context.Context, rootCert string, caCert *x509.Certificate) error {
+	// add-trusted-cert also works if the certificate is already in the
+	// keychain, so only skip it if it's actually trusted.
+	if inKeychain(systemKeychain, caCert) && isTrusted(caCert) {
+		if t.User && !inKeychain(loginKeychain(), caCert) {
+			return t.installUser(ctx, rootCert)
+		}
+		return nil
+	}
+
+	cmd := privCmd(ctx, "security", "add-trusted-cert", "-d", "-k",
+		systemKeychain, rootCert)
+	_, err := privOutput(cmd)
 	if err != nil {
 		return err
 	} // security add-trusted-cert
@@ -77,8 +149,8 @@ func (t Darwin) Install(rootCert string, caCert *x509.Certificate) error {
 	} // (err, "failed to create temp file")
 	defer os.Remove(plistFile.Name())
 
-	cmd = privCmd("security", "trust-settings-export", "-d", plistFile.Name())
-	_, err = cmd.CombinedOutput()
+	cmd = privCmd(ctx, "security", "trust-settings-export", "-d", plistFile.Name())
+	_, err = privOutput(cmd)
 	if err != nil {
 		return err
 	} // "security trust-settings-export"
@@ -91,7 +163,7 @@ func (t Darwin) Install(rootCert string, caCert *x509.Certificate) error {
 	var plistRoot map[string]interface{}
 	_, err = plist.Unmarshal(plistData, &plistRoot)
 	if err != nil {
-		fmt.Errorf("parse trust settings: %w", err)
+		return fmt.Errorf("parse trust settings: %w", err)
 	}
 
 	rootSubjectASN1, _ := asn1.Marshal(caCert.Subject.ToRDNSequence())
@@ -123,21 +195,58 @@ func (t Darwin) Install(rootCert string, caCert *x509.Certificate) error {
 		return err
 	} //fatalIfErr(err, "failed to write trust settings")
 
-	cmd = privCmd("security", "trust-settings-import", "-d", plistFile.Name())
-	_, err = cmd.CombinedOutput()
+	cmd = privCmd(ctx, "security", "trust-settings-import", "-d", plistFile.Name())
+	_, err = privOutput(cmd)
 	if err != nil {
 		return err
 	} // fatalIfCmdErr(err, "security trust-settings-import", out)
 
+	if t.User {
+		return t.installUser(ctx, rootCert)
+	}
 	return nil
 }
 
+// installUser adds the certificate to the login keychain; this is run as the
+// current user, rather than with privCmd().
+func (t Darwin) installUser(ctx context.Context, rootCert string) error {
+	out, err := exec.CommandContext(ctx, "security", "add-trusted-cert", "-r", "trustRoot",
+		"-k", loginKeychain(), rootCert).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("security add-trusted-cert for login keychain: %w: %s", err, out)
+	}
+	return nil
+}
+
+func loginKeychain() string {
+	return filepath.Join(os.Getenv("HOME"), "Library", "Keychains", "login.keychain-db")
+}
+
 func (t Darwin) Uninstall(rootCert string, caCert *x509.Certificate) error {
-	// TODO
-	// cmd := privCmd("security", "remove-trusted-cert", "-d", filepath.Join(m.CAROOT, rootName))
-	// out, err := cmd.CombinedOutput()
-	// if err != nil {
-	// 	return err
-	// } // fatalIfCmdErr(err, "security remove-trusted-cert", out)
+	return t.UninstallContext(context.Background(), rootCert, caCert)
+}
+
+func (t Darwin) UninstallContext(ctx context.Context, rootCert string, caCert *x509.Certificate) error {
+	if t.User && inKeychain(loginKeychain(), caCert) {
+		out, err := exec.CommandContext(ctx, "security", "remove-trusted-cert", rootCert).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("security remove-trusted-cert for login keychain: %w: %s", err, out)
+		}
+		out, err = exec.CommandContext(ctx, "security", "delete-certificate",
+			"-Z", fmt.Sprintf("%X", sha1.Sum(caCert.Raw)), loginKeychain()).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("security delete-certificate for login keychain: %w: %s", err, out)
+		}
+	}
+
+	// remove-trusted-cert only removes the trust settings and keeps the
+	// certificate in the System keychain, so check if it's still trusted.
+	if !inKeychain(systemKeychain, caCert) || !isTrusted(caCert) {
+		return nil
+	}
+	out, err := privOutput(privCmd(ctx, "security", "remove-trusted-cert", "-d", rootCert))
+	if err != nil {
+		return fmt.Errorf("security remove-trusted-cert: %w: %s", err, out)
+	}
 	return nil
 }
@@ -7,7 +7,7 @@ import (
 	"errors"
 )
 
-type Darwin struct{}
+type Darwin struct{ User bool }
 
 func (Darwin) Name() string                              { return "Darwin" }
 func (Darwin) Verbose(v bool)                            {}
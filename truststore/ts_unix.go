@@ -4,19 +4,39 @@ package truststore
 
 import (
 	"bytes"
+	"context"
 	"crypto/x509"
+	"encoding/pem"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"runtime"
 	"strings"
 )
 
 var (
-	firefoxProfile = os.Getenv("HOME") + "/.mozilla/firefox/*"
-	nssBrowsers    = "Firefox and Chrome/Chromium"
+	firefoxProfiles = []string{
+		os.Getenv("HOME") + "/.mozilla/firefox/*",
+		os.Getenv("HOME") + "/snap/firefox/common/.mozilla/firefox/*",          // Snap
+		os.Getenv("HOME") + "/.var/app/org.mozilla.firefox/.mozilla/firefox/*", // Flatpak
+	}
+	nssBrowsers = "Firefox and Chrome/Chromium"
+
+	// OpenBSD doesn't have a directory for extra certificates; everything is
+	// in a single bundle which we append to.
+	trustBundle = func() string {
+		if runtime.GOOS == "openbsd" {
+			return "/etc/ssl/cert.pem"
+		}
+		return ""
+	}()
 
 	trustFile, trustCmd = func() (string, []string) {
 		switch {
+		case runtime.GOOS == "netbsd" && pathExists("/etc/openssl/certs/"):
+			return "/etc/openssl/certs/%s.pem",
+				[]string{"openssl", "rehash", "/etc/openssl/certs"}
+
 		case pathExists("/etc/pki/ca-trust/source/anchors/"):
 			return "/etc/pki/ca-trust/source/anchors/%s.pem",
 				[]string{"update-ca-trust", "extract"}
@@ -66,32 +86,84 @@ func (Unix) OnSystem() bool {
 }
 
 func (t Unix) HasCert(caCert *x509.Certificate) bool {
-	// TODO
-	return false
+	c, err := t.FindInstalled(caCert)
+	return err == nil && c != nil && bytes.Equal(c.Raw, caCert.Raw)
+}
+
+func (t Unix) FindInstalled(caCert *x509.Certificate) (*x509.Certificate, error) {
+	if trustBundle != "" {
+		bundle, err := ioutil.ReadFile(trustBundle)
+		if err != nil {
+			return nil, fmt.Errorf("truststore.Unix: %w", err)
+		}
+		for {
+			var b *pem.Block
+			b, bundle = pem.Decode(bundle)
+			if b == nil {
+				return nil, nil
+			}
+			if b.Type == "CERTIFICATE" && bytes.Equal(b.Bytes, caCert.Raw) {
+				return x509.ParseCertificate(b.Bytes)
+			}
+		}
+	}
+	if trustCmd == nil {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadFile(t.systemTrust(caCert))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("truststore.Unix: %w", err)
+	}
+	c, err := parseCert(data)
+	if err != nil {
+		return nil, fmt.Errorf("truststore.Unix: %w", err)
+	}
+	return c, nil
 }
 
 func (t Unix) Install(rootCert string, caCert *x509.Certificate) error {
+	return t.InstallContext(context.Background(), rootCert, caCert)
+}
+
+func (t Unix) InstallContext(ctx context.Context, rootCert string, caCert *x509.Certificate) error {
+	if trustBundle != "" {
+		if t.verbose {
+			Log.Printf("truststore.Unix: appending to bundle %q", trustBundle)
+		}
+		return t.installBundle(ctx, caCert)
+	}
 	if trustCmd == nil {
 		return fmt.Errorf("truststore.Unix: not yet supported on this Unix, but %s will still work", nssBrowsers)
 	}
+	if t.HasCert(caCert) {
+		return nil // Don't run trustCmd again if it's already there.
+	}
+	if t.verbose {
+		Log.Printf("truststore.Unix: trust file %q; trust command %q; writing to %q",
+			trustFile, trustCmd, t.systemTrust(caCert))
+	}
 
 	cert, err := ioutil.ReadFile(rootCert)
 	if err != nil {
 		return fmt.Errorf("truststore.Unix: read root certificate: %w", err)
 	}
 
-	cmd := privCmd("tee", t.systemTrust(caCert))
+	cmd := privCmd(ctx, "tee", t.systemTrust(caCert))
 	cmd.Stdin = bytes.NewReader(cert)
-	out, err := cmd.CombinedOutput()
+	out, err := privOutput(cmd)
 	if err != nil {
-		fmt.Println(string(out))
+		Log.Print(string(out))
 		return fmt.Errorf("truststore.Unix: %w", err)
 	}
 
-	cmd = privCmd(trustCmd...)
-	out, err = cmd.CombinedOutput()
+	cmd = privCmd(ctx, trustCmd...)
+	out, err = privOutput(cmd)
 	if err != nil {
-		fmt.Println(string(out))
+		Log.Print(string(out))
 		return fmt.Errorf("truststore.Unix: %w", err)
 	}
 
@@ -99,18 +171,25 @@ func (t Unix) Install(rootCert string, caCert *x509.Certificate) error {
 }
 
 func (t Unix) Uninstall(rootCert string, caCert *x509.Certificate) error {
+	return t.UninstallContext(context.Background(), rootCert, caCert)
+}
+
+func (t Unix) UninstallContext(ctx context.Context, rootCert string, caCert *x509.Certificate) error {
+	if trustBundle != "" {
+		return t.uninstallBundle(ctx, caCert)
+	}
 	if trustCmd == nil {
 		return nil
 	}
 
-	cmd := privCmd("rm", "-f", t.systemTrust(caCert))
-	_, err := cmd.CombinedOutput()
+	cmd := privCmd(ctx, "rm", "-f", t.systemTrust(caCert))
+	_, err := privOutput(cmd)
 	if err != nil {
 		return fmt.Errorf("truststore.Unix: %w", err)
 	}
 
-	cmd = privCmd(trustCmd...)
-	_, err = cmd.CombinedOutput()
+	cmd = privCmd(ctx, trustCmd...)
+	_, err = privOutput(cmd)
 	if err != nil {
 		return fmt.Errorf("truststore.Unix: %w", err)
 	}
@@ -118,5 +197,45 @@ func (t Unix) Uninstall(rootCert string, caCert *x509.Certificate) error {
 }
 
 func (Unix) systemTrust(caCert *x509.Certificate) string {
-	return fmt.Sprintf(trustFile, strings.ReplaceAll(caName(caCert), " ", "_"))
+	return fmt.Sprintf(trustFile, strings.ReplaceAll(CAName(caCert), " ", "_"))
+}
+
+func (t Unix) installBundle(ctx context.Context, caCert *x509.Certificate) error {
+	bundle, err := ioutil.ReadFile(trustBundle)
+	if err != nil {
+		return fmt.Errorf("truststore.Unix: %w", err)
+	}
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw})
+	if bytes.Contains(bundle, cert) {
+		return nil
+	}
+
+	cmd := privCmd(ctx, "tee", "-a", trustBundle)
+	cmd.Stdin = bytes.NewReader(cert)
+	out, err := privOutput(cmd)
+	if err != nil {
+		Log.Print(string(out))
+		return fmt.Errorf("truststore.Unix: %w", err)
+	}
+	return nil
+}
+
+func (t Unix) uninstallBundle(ctx context.Context, caCert *x509.Certificate) error {
+	bundle, err := ioutil.ReadFile(trustBundle)
+	if err != nil {
+		return fmt.Errorf("truststore.Unix: %w", err)
+	}
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw})
+	if !bytes.Contains(bundle, cert) {
+		return nil
+	}
+
+	cmd := privCmd(ctx, "tee", trustBundle)
+	cmd.Stdin = bytes.NewReader(bytes.ReplaceAll(bundle, cert, nil))
+	out, err := privOutput(cmd)
+	if err != nil {
+		Log.Print(string(out))
+		return fmt.Errorf("truststore.Unix: %w", err)
+	}
+	return nil
 }
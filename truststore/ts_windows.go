@@ -3,6 +3,7 @@
 package truststore
 
 import (
+	"bytes"
 	"crypto/x509"
 	"encoding/pem"
 	"fmt"
@@ -15,7 +16,7 @@ import (
 )
 
 var (
-	firefoxProfile      = os.Getenv("USERPROFILE") + "\\AppData\\Roaming\\Mozilla\\Firefox\\Profiles"
+	firefoxProfiles     = []string{os.Getenv("USERPROFILE") + "\\AppData\\Roaming\\Mozilla\\Firefox\\Profiles"}
 	certutilInstallHelp = "" // certutil unsupported on Windows
 	nssBrowsers         = "Firefox"
 )
@@ -27,8 +28,14 @@ func (t *Windows) Verbose(v bool) { t.verbose = v }
 func (Windows) OnSystem() bool    { return runtime.GOOS == "windows" }
 
 func (t Windows) HasCert(caCert *x509.Certificate) bool {
-	// TODO
-	return false
+	store, err := openWindowsRootStore()
+	if err != nil {
+		return false
+	}
+	defer store.close()
+
+	has, err := store.hasCert(caCert.Raw)
+	return err == nil && has
 }
 
 func (t Windows) Install(rootCert string, caCert *x509.Certificate) error {
@@ -50,6 +57,11 @@ func (t Windows) Install(rootCert string, caCert *x509.Certificate) error {
 
 	defer store.close()
 
+	// Already installed; adding it again would replace the existing cert.
+	if has, err := store.hasCert(cert); err == nil && has {
+		return nil
+	}
+
 	// Add cert
 	err = store.addCert(cert)
 	if err != nil {
@@ -86,6 +98,7 @@ var (
 	procCertDeleteCertificateFromStore   = modcrypt32.NewProc("CertDeleteCertificateFromStore")
 	procCertDuplicateCertificateContext  = modcrypt32.NewProc("CertDuplicateCertificateContext")
 	procCertEnumCertificatesInStore      = modcrypt32.NewProc("CertEnumCertificatesInStore")
+	procCertFreeCertificateContext       = modcrypt32.NewProc("CertFreeCertificateContext")
 	procCertOpenSystemStoreW             = modcrypt32.NewProc("CertOpenSystemStoreW")
 )
 
@@ -121,36 +134,59 @@ func (w windowsRootStore) addCert(cert []byte) error {
 	return fmt.Errorf("adding cert: %v", err)
 }
 
-func (w windowsRootStore) deleteCertsWithSerial(serial *big.Int) (bool, error) {
-	// Go over each, deleting the ones we find
+// eachCert calls fn for every certificate in the store, with the context and
+// DER-encoded certificate, until fn returns false or an error. The context is
+// only valid until fn returns.
+func (w windowsRootStore) eachCert(fn func(cert *syscall.CertContext, der []byte) (bool, error)) error {
 	var cert *syscall.CertContext
-	deletedAny := false
 	for {
-		// Next enum
 		certPtr, _, err := procCertEnumCertificatesInStore.Call(uintptr(w), uintptr(unsafe.Pointer(cert)))
 		if cert = (*syscall.CertContext)(unsafe.Pointer(certPtr)); cert == nil {
 			if errno, ok := err.(syscall.Errno); ok && errno == 0x80092004 {
-				break
+				return nil
 			}
-			return deletedAny, fmt.Errorf("enumerating certs: %v", err)
+			return fmt.Errorf("enumerating certs: %v", err)
 		}
 
-		// Parse cert
-		certBytes := (*[1 << 20]byte)(unsafe.Pointer(cert.EncodedCert))[:cert.Length]
-		parsedCert, err := x509.ParseCertificate(certBytes)
+		der := (*[1 << 20]byte)(unsafe.Pointer(cert.EncodedCert))[:cert.Length]
+		more, err := fn(cert, der)
+		if err != nil || !more {
+			// Enumeration frees the previous context, but we stop early here.
+			procCertFreeCertificateContext.Call(uintptr(unsafe.Pointer(cert)))
+			return err
+		}
+	}
+}
+
+// hasCert reports if the DER-encoded cert is in the store.
+func (w windowsRootStore) hasCert(der []byte) (bool, error) {
+	var found bool
+	err := w.eachCert(func(_ *syscall.CertContext, c []byte) (bool, error) {
+		found = bytes.Equal(c, der)
+		return !found, nil
+	})
+	return found, err
+}
 
+func (w windowsRootStore) deleteCertsWithSerial(serial *big.Int) (bool, error) {
+	deletedAny := false
+	err := w.eachCert(func(cert *syscall.CertContext, der []byte) (bool, error) {
 		// We'll just ignore parse failures for now
-		if err == nil && parsedCert.SerialNumber != nil && parsedCert.SerialNumber.Cmp(serial) == 0 {
-			// Duplicate the context so it doesn't stop the enum when we delete it
-			dupCertPtr, _, err := procCertDuplicateCertificateContext.Call(uintptr(unsafe.Pointer(cert)))
-			if dupCertPtr == 0 {
-				return deletedAny, fmt.Errorf("duplicating context: %v", err)
-			}
-			if ret, _, err := procCertDeleteCertificateFromStore.Call(dupCertPtr); ret == 0 {
-				return deletedAny, fmt.Errorf("deleting certificate: %v", err)
-			}
-			deletedAny = true
+		parsedCert, err := x509.ParseCertificate(der)
+		if err != nil || parsedCert.SerialNumber == nil || parsedCert.SerialNumber.Cmp(serial) != 0 {
+			return true, nil
 		}
-	}
-	return deletedAny, nil
+
+		// Duplicate the context so it doesn't stop the enum when we delete it
+		dupCertPtr, _, err := procCertDuplicateCertificateContext.Call(uintptr(unsafe.Pointer(cert)))
+		if dupCertPtr == 0 {
+			return false, fmt.Errorf("duplicating context: %v", err)
+		}
+		if ret, _, err := procCertDeleteCertificateFromStore.Call(dupCertPtr); ret == 0 {
+			return false, fmt.Errorf("deleting certificate: %v", err)
+		}
+		deletedAny = true
+		return true, nil
+	})
+	return deletedAny, err
 }
@@ -2,11 +2,17 @@ package zcert
 
 import (
 	"bytes"
+	"container/list"
+	"context"
 	"crypto"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/rsa"
 	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
@@ -24,6 +30,7 @@ import (
 	"os/user"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"sync"
 	"time"
 
@@ -33,9 +40,237 @@ import (
 // CARoot is a root certificate that's used to sign certificates with.
 type CARoot struct {
 	Verbose bool // Print verbose output to stderr.
+	Quiet   bool // Don't print progress information to stdout.
+
+	// Backdate NotBefore by this much to allow for clock skew between machines;
+	// defaults to a minute if 0. Set to a negative value to use the current
+	// time.
+	NotBeforeSkew time.Duration
+
+	// Directory to store the root certificate in; this overrides the CAROOT
+	// environment variable, and the "zcert" subdirectory is used just like
+	// with CAROOT.
+	Dir string
+
+	// Store the root certificate in the user's cache directory (or the
+	// system's temporary directory) if the default location isn't writable.
+	// The root certificate probably won't persist across reboots when this
+	// happens.
+	//
+	// The directory is resolved once by Create(), Import(), or Load().
+	CacheFallback bool
+
+	// Options for finding the trust stores in Install() and Uninstall().
+	// Verbose is also set if CARoot.Verbose is.
+	StoreOptions truststore.Options
+
+	// Maximum number of errors to report from Install() and Uninstall(); any
+	// errors after this are summarized as "(and N more)". 0 means no limit.
+	MaxErrors int
+
+	// Skip a trust store in Install() and Uninstall() if it takes longer than
+	// this, and continue with the next one. Any commands it's running are
+	// killed if the store implements truststore.ContextStore. 0 means no
+	// timeout.
+	StoreTimeout time.Duration
+
+	// Called for every trust store in Install() and Uninstall() with one of
+	// the Stage* constants, so applications can show their own progress;
+	// err is only set for StageFailed. The default prints the progress to
+	// stdout, unless Quiet is set.
+	//
+	// This is called from multiple goroutines, but never concurrently.
+	OnProgress func(store, stage string, err error)
+
+	// Called with warnings about things that aren't an error, such as
+	// shortening the validity to the root's. Warnings are ignored if this is
+	// nil.
+	OnWarning func(msg string)
+
+	// Method to derive the SubjectKeyId of new root certificates; the default
+	// is the SHA-1 method from RFC 5280. Certificates signed with the root
+	// get an AuthorityKeyId which references this.
+	KeyID KeyIDMethod
+
+	// Algorithm for the keys of new root certificates and certificates; the
+	// default is ECDSA with the P-256 curve.
+	KeyAlgorithm KeyAlgorithm
+
+	// Create an RSA root certificate rather than an ECDSA one, as some older
+	// Java and Android versions don't handle ECDSA roots well. Certificates
+	// signed with it still use KeyAlgorithm. This is ignored if KeyAlgorithm
+	// is set to anything other than ECDSAP256.
+	Compat bool
+
+	// Subject for new root certificates; any fields that are set override
+	// the defaults.
+	//
+	// The Organization and OrganizationalUnit are also used as the defaults
+	// for certificates, and the Organization is used in the name of the root
+	// in trust stores.
+	Subject pkix.Name
+
+	// How long certificates are valid for; the default is one year. Note
+	// that some clients reject server certificates that are valid for more
+	// than 398 days.
+	//
+	// Certificates are never valid for longer than the root certificate;
+	// NotAfter is set to the root's NotAfter if it would be later, unless
+	// AllowLongerThanRoot is set.
+	Validity time.Duration
+
+	// Allow certificates that are valid for longer than the root
+	// certificate. Clients will reject these once the root expires; this is
+	// mostly useful to test how software deals with that.
+	AllowLongerThanRoot bool
+
+	// URLs of OCSP responders to add to certificates in the Authority
+	// Information Access extension, for testing clients that check OCSP.
+	OCSPServer []string
+
+	// How long new root certificates are valid for; the default is 10 years.
+	RootValidity time.Duration
+
+	// Maximum number of intermediate CAs below new root certificates. The
+	// default of 0 doesn't allow any intermediates; set to -1 for no limit.
+	MaxPathLen int
+
+	// Get the current time for NotBefore and NotAfter of new certificates;
+	// the default is time.Now().
+	//
+	// This is intended for reproducible test fixtures; also see SerialNumber.
+	Now func() time.Time
+
+	// Generate serial numbers for new certificates; the default is a random
+	// 128-bit number. CertOptions.Serial takes precedence over this.
+	SerialNumber func() (*big.Int, error)
+
+	// Source of randomness for keys, serial numbers, and signatures; the
+	// default is crypto/rand.Reader. Never set this to anything other than a
+	// cryptographically secure source outside of tests.
+	//
+	// Note that Go's crypto packages may ignore this and always use their own
+	// source for some operations, so this doesn't guarantee deterministic
+	// output.
+	Rand io.Reader
+
+	// Store the key of new root certificates in rootCA.pem along with the
+	// certificate, instead of in a separate rootCA-key.pem. Existing roots
+	// are always loaded from whichever layout they were stored in.
+	SingleFile bool
+
+	cert  *x509.Certificate
+	chain []*x509.Certificate // Intermediates, from CreateIntermediate().
+	root  *x509.Certificate   // Root if this is an intermediate.
+	key   crypto.PrivateKey
+
+	dir         string // Resolved storeDir(), set by Create, Import, and Load.
+	dirFallback bool
+}
+
+// KeyIDMethod is a method to derive the SubjectKeyId from the public key.
+type KeyIDMethod int
+
+// Key ID methods.
+const (
+	KeyIDSHA1   KeyIDMethod = iota // SHA-1 of the public key (RFC 5280, 4.2.1.2).
+	KeyIDSHA256                    // Leftmost 160 bits of SHA-256 (RFC 7093, method 1).
+	KeyIDSHA384                    // Leftmost 160 bits of SHA-384 (RFC 7093, method 2).
+	KeyIDSHA512                    // Leftmost 160 bits of SHA-512 (RFC 7093, method 3).
+)
+
+// ParseKeyIDMethod parses a key ID method name: "sha1", "sha256", "sha384",
+// or "sha512".
+func ParseKeyIDMethod(s string) (KeyIDMethod, error) {
+	switch strings.ToLower(s) {
+	case "sha1", "":
+		return KeyIDSHA1, nil
+	case "sha256":
+		return KeyIDSHA256, nil
+	case "sha384":
+		return KeyIDSHA384, nil
+	case "sha512":
+		return KeyIDSHA512, nil
+	}
+	return 0, fmt.Errorf("zcert.ParseKeyIDMethod: unknown key ID method: %q", s)
+}
+
+func (m KeyIDMethod) sum(pubKey []byte) []byte {
+	var h []byte
+	switch m {
+	default:
+		s := sha1.Sum(pubKey)
+		h = s[:]
+	case KeyIDSHA256:
+		s := sha256.Sum256(pubKey)
+		h = s[:]
+	case KeyIDSHA384:
+		s := sha512.Sum384(pubKey)
+		h = s[:]
+	case KeyIDSHA512:
+		s := sha512.Sum512(pubKey)
+		h = s[:]
+	}
+	return h[:20]
+}
+
+// KeyAlgorithm is the algorithm and size for generating private keys.
+type KeyAlgorithm int
+
+// Key algorithms.
+const (
+	ECDSAP256 KeyAlgorithm = iota // ECDSA with the NIST P-256 curve.
+	ECDSAP384                     // ECDSA with the NIST P-384 curve.
+	RSA2048                       // 2048-bit RSA.
+	RSA3072                       // 3072-bit RSA.
+	RSA4096                       // 4096-bit RSA.
+	Ed25519                       // Ed25519; not all clients support this.
+)
+
+var keyAlgorithms = []string{"p256", "p384", "rsa2048", "rsa3072", "rsa4096", "ed25519"}
+
+// ParseKeyAlgorithm parses a key algorithm name: "p256", "p384", "rsa2048",
+// "rsa3072", "rsa4096", or "ed25519". "ecdsa" and "rsa" are accepted as aliases for
+// "p256" and "rsa2048".
+func ParseKeyAlgorithm(s string) (KeyAlgorithm, error) {
+	switch strings.ToLower(s) {
+	case "", "ecdsa":
+		return ECDSAP256, nil
+	case "rsa":
+		return RSA2048, nil
+	}
+	for i, a := range keyAlgorithms {
+		if strings.EqualFold(s, a) {
+			return KeyAlgorithm(i), nil
+		}
+	}
+	return 0, fmt.Errorf("zcert.ParseKeyAlgorithm: unknown key algorithm: %q", s)
+}
+
+func (a KeyAlgorithm) String() string {
+	if a < 0 || int(a) >= len(keyAlgorithms) {
+		return fmt.Sprintf("KeyAlgorithm(%d)", int(a))
+	}
+	return keyAlgorithms[a]
+}
 
-	cert *x509.Certificate
-	key  crypto.PrivateKey
+func (a KeyAlgorithm) generate(r io.Reader) (crypto.PrivateKey, error) {
+	switch a {
+	case ECDSAP256:
+		return ecdsa.GenerateKey(elliptic.P256(), r)
+	case ECDSAP384:
+		return ecdsa.GenerateKey(elliptic.P384(), r)
+	case RSA2048:
+		return rsa.GenerateKey(r, 2048)
+	case RSA3072:
+		return rsa.GenerateKey(r, 3072)
+	case RSA4096:
+		return rsa.GenerateKey(r, 4096)
+	case Ed25519:
+		_, k, err := ed25519.GenerateKey(r)
+		return k, err
+	}
+	return nil, fmt.Errorf("unknown key algorithm: %s", a)
 }
 
 // New creates a new instance of CARoot. It will load an existing root
@@ -56,49 +291,150 @@ func (ca CARoot) Certificate() *x509.Certificate {
 	return ca.cert
 }
 
+// Chain gets the certificates that clients need to verify certificates signed
+// with ca: any intermediates from CreateIntermediate(), followed by the root
+// certificate.
+func (ca CARoot) Chain() []*x509.Certificate {
+	chain := append(make([]*x509.Certificate, 0, len(ca.chain)+1), ca.chain...)
+	return append(chain, ca.rootCert())
+}
+
+// RootCertificate gets the self-signed root certificate; this is the same as
+// Certificate(), except for intermediates from CreateIntermediate().
+func (ca CARoot) RootCertificate() *x509.Certificate {
+	return ca.rootCert()
+}
+
 // Create a new root certificate; this will return an error if a root CA already
 // exist.
 func (ca *CARoot) Create() error {
-	rootCert, rootKey := ca.StorePath()
-	if rootCert == "" {
-		return errors.New("zcert.Create: can't find a location to store the root certificate; set CAROOT")
+	ca.resolveDir()
+	err := ca.checkStore()
+	if err != nil {
+		return fmt.Errorf("zcert.Create: %w", err)
 	}
 
-	if ca.Exists() {
-		return fmt.Errorf("zcert.Create: CA root already exists at %q", rootCert)
+	pc, privKey, err := ca.generate()
+	if err != nil {
+		return fmt.Errorf("zcert.Create: %w", err)
 	}
 
-	err := os.MkdirAll(filepath.Dir(rootCert), 0755)
+	err = ca.store(pc, privKey)
 	if err != nil {
 		return fmt.Errorf("zcert.Create: %w", err)
 	}
+	return nil
+}
 
-	privKey, err := generateKey()
+// Import an existing CA certificate and key, instead of creating a new one
+// with Create(). This will return ErrExists if a root CA already exists, after
+// the certificate and key are validated.
+//
+// The certificate must be a CA certificate with the KeyUsageCertSign key usage,
+// and the key must belong to it. Only the first certificate in certPEM is used.
+func (ca *CARoot) Import(certPEM, keyPEM []byte) error {
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
 	if err != nil {
-		return fmt.Errorf("zcert.Create: generating private key: %w", err)
+		return fmt.Errorf("zcert.Import: %w", err)
+	}
+	pc, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("zcert.Import: %w", err)
+	}
+	if !pc.BasicConstraintsValid || !pc.IsCA {
+		return errors.New("zcert.Import: not a CA certificate")
+	}
+	if pc.KeyUsage&x509.KeyUsageCertSign == 0 {
+		return errors.New("zcert.Import: CA certificate doesn't have the certSign key usage")
 	}
-	pubKey := privKey.(crypto.Signer).Public()
 
-	spkiASN1, err := x509.MarshalPKIXPublicKey(pubKey)
+	ca.resolveDir()
+	err = ca.checkStore()
+	if err != nil {
+		return fmt.Errorf("zcert.Import: %w", err)
+	}
+	err = ca.store(pc, pair.PrivateKey)
 	if err != nil {
-		return fmt.Errorf("zcert.Create: encode public key: %w", err)
+		return fmt.Errorf("zcert.Import: %w", err)
 	}
+	return nil
+}
 
-	var spki struct {
-		Algorithm        pkix.AlgorithmIdentifier
-		SubjectPublicKey asn1.BitString
+// ErrExists is returned by Create() and Import() if a root CA already exists.
+var ErrExists = errors.New("CA root already exists")
+
+// checkStore checks if a new root can be stored, and creates the directory.
+func (ca CARoot) checkStore() error {
+	rootCert, _ := ca.StorePath()
+	if rootCert == "" {
+		return errors.New("can't find a location to store the root certificate; set CAROOT")
 	}
-	_, err = asn1.Unmarshal(spkiASN1, &spki)
+
+	if ca.Exists() {
+		return fmt.Errorf("%w at %q", ErrExists, rootCert)
+	}
+	if _, fallback := ca.storeDir(); fallback {
+		ca.warn("default location not writable; storing root certificate in %q, which may not persist across reboots",
+			filepath.Dir(rootCert))
+	}
+
+	return os.MkdirAll(filepath.Dir(rootCert), 0755)
+}
+
+// store the root certificate and key, and use it.
+func (ca *CARoot) store(cert *x509.Certificate, key crypto.PrivateKey) error {
+	rootCert, rootKey := ca.StorePath()
+	privDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("encode CA key: %w", err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER})
+
+	if rootCert == rootKey {
+		err = ioutil.WriteFile(rootCert, append(certPEM, keyPEM...), 0400)
+		if err != nil {
+			return fmt.Errorf("save CA certificate and key: %w", err)
+		}
+	} else {
+		err = ioutil.WriteFile(rootKey, keyPEM, 0400)
+		if err != nil {
+			return fmt.Errorf("save CA key: %w", err)
+		}
+
+		err = ioutil.WriteFile(rootCert, certPEM, 0644)
+		if err != nil {
+			return fmt.Errorf("save CA certificate: %w", err)
+		}
+	}
+
+	ca.cert = cert
+	ca.key = key
+	return nil
+}
+
+// generate a new root certificate and key, without storing it.
+func (ca CARoot) generate() (*x509.Certificate, crypto.PrivateKey, error) {
+	alg := ca.KeyAlgorithm
+	if ca.Compat && alg == ECDSAP256 {
+		alg = RSA3072
+	}
+	privKey, err := alg.generate(ca.rand())
 	if err != nil {
-		return fmt.Errorf("zcert.Create: decode public key: %w", err)
+		return nil, nil, fmt.Errorf("generating private key: %w", err)
+	}
+	pubKey := privKey.(crypto.Signer).Public()
+
+	skid, err := ca.subjectKeyID(pubKey)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	serial, err := randomSerialNumber()
+	serial, err := ca.serialNumber()
 	if err != nil {
-		return fmt.Errorf("zcert.Create: generating serial number: %w", err)
+		return nil, nil, fmt.Errorf("generating serial number: %w", err)
 	}
 
-	skid := sha1.Sum(spki.SubjectPublicKey.Bytes)
 	tpl := &x509.Certificate{
 		SerialNumber: serial,
 		Subject: pkix.Name{
@@ -110,43 +446,131 @@ func (ca *CARoot) Create() error {
 			// https://github.com/FiloSottile/mkcert/issues/47
 			CommonName: "zcert " + userAndHostname(),
 		},
-		SubjectKeyId: skid[:],
+		SubjectKeyId: skid,
 
-		NotAfter:  time.Now().AddDate(10, 0, 0),
-		NotBefore: time.Now(),
+		NotAfter:  ca.rootNotAfter(),
+		NotBefore: ca.notBefore(),
 
-		KeyUsage: x509.KeyUsageCertSign,
+		KeyUsage: x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
 
 		BasicConstraintsValid: true,
 		IsCA:                  true,
-		MaxPathLenZero:        true,
+		MaxPathLen:            ca.MaxPathLen,
+		MaxPathLenZero:        ca.MaxPathLen == 0,
 	}
 
-	cert, err := x509.CreateCertificate(rand.Reader, tpl, tpl, pubKey, privKey)
+	mergeName(&tpl.Subject, ca.Subject)
+
+	cert, err := x509.CreateCertificate(ca.rand(), tpl, tpl, pubKey, privKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("generate CA certificate: %w", err)
+	}
+	pc, err := x509.ParseCertificate(cert)
 	if err != nil {
-		return fmt.Errorf("zcert.Create: generate CA certificate: %w", err)
+		return nil, nil, err
 	}
+	return pc, privKey, nil
+}
 
-	privDER, err := x509.MarshalPKCS8PrivateKey(privKey)
+// subjectKeyID gets the SubjectKeyId for pub with the KeyID method.
+func (ca CARoot) subjectKeyID(pub crypto.PublicKey) ([]byte, error) {
+	spkiASN1, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, fmt.Errorf("encode public key: %w", err)
+	}
+
+	var spki struct {
+		Algorithm        pkix.AlgorithmIdentifier
+		SubjectPublicKey asn1.BitString
+	}
+	_, err = asn1.Unmarshal(spkiASN1, &spki)
 	if err != nil {
-		return fmt.Errorf("zcert.Create: encode CA key: %w", err)
+		return nil, fmt.Errorf("decode public key: %w", err)
 	}
+	return ca.KeyID.sum(spki.SubjectPublicKey.Bytes), nil
+}
 
-	err = ioutil.WriteFile(rootKey, pem.EncodeToMemory(
-		&pem.Block{Type: "PRIVATE KEY", Bytes: privDER}), 0400)
+// CreateIntermediate creates a new intermediate CA signed with the root
+// certificate; the root must have been created with a MaxPathLen that allows
+// this.
+//
+// The returned CARoot signs certificates with the intermediate, and includes
+// the intermediate in the output of MakeCert() and SignCSR() so that clients
+// get the full chain. It's not stored on disk, and should only be used to
+// create certificates.
+func (ca CARoot) CreateIntermediate() (CARoot, error) {
+	if ca.cert == nil || ca.key == nil {
+		err := ca.Load()
+		if err != nil {
+			return CARoot{}, fmt.Errorf("zcert.CreateIntermediate: %w", err)
+		}
+	}
+	if ca.cert.MaxPathLen == 0 && ca.cert.MaxPathLenZero {
+		return CARoot{}, errors.New("zcert.CreateIntermediate: the CA doesn't allow intermediates; create the root with a larger MaxPathLen")
+	}
+
+	privKey, err := ca.KeyAlgorithm.generate(ca.rand())
+	if err != nil {
+		return CARoot{}, fmt.Errorf("zcert.CreateIntermediate: generating private key: %w", err)
+	}
+	pubKey := privKey.(crypto.Signer).Public()
+	skid, err := ca.subjectKeyID(pubKey)
+	if err != nil {
+		return CARoot{}, fmt.Errorf("zcert.CreateIntermediate: %w", err)
+	}
+	serial, err := ca.serialNumber()
 	if err != nil {
-		return fmt.Errorf("zcert.Create: save CA key: %w", err)
+		return CARoot{}, fmt.Errorf("zcert.CreateIntermediate: generating serial number: %w", err)
 	}
 
-	err = ioutil.WriteFile(rootCert, pem.EncodeToMemory(
-		&pem.Block{Type: "CERTIFICATE", Bytes: cert}), 0644)
+	// One less than the parent, or no limit if the parent has no limit.
+	pathLen := -1
+	if ca.cert.MaxPathLen > 0 {
+		pathLen = ca.cert.MaxPathLen - 1
+	}
+	tpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization:       ca.cert.Subject.Organization,
+			OrganizationalUnit: ca.cert.Subject.OrganizationalUnit,
+			CommonName:         "zcert intermediate " + userAndHostname(),
+		},
+		SubjectKeyId: skid,
+
+		NotAfter:  ca.cert.NotAfter,
+		NotBefore: ca.notBefore(),
+
+		KeyUsage: x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLen:            pathLen,
+		MaxPathLenZero:        pathLen == 0,
+	}
+
+	cert, err := x509.CreateCertificate(ca.rand(), tpl, ca.cert, pubKey, ca.key)
 	if err != nil {
-		return fmt.Errorf("zcert.Create: save CA certificate: %w", err)
+		return CARoot{}, fmt.Errorf("zcert.CreateIntermediate: generating certificate: %w", err)
+	}
+	pc, err := x509.ParseCertificate(cert)
+	if err != nil {
+		return CARoot{}, fmt.Errorf("zcert.CreateIntermediate: %w", err)
 	}
 
-	ca.cert = tpl
-	ca.key = privKey
-	return nil
+	ica := ca
+	ica.cert, ica.key = pc, privKey
+	ica.chain = append([]*x509.Certificate{pc}, ca.chain...)
+	ica.root = ca.rootCert()
+	return ica, nil
+}
+
+// rootCert gets the root certificate, which may not be the certificate that
+// signs new certificates if this is an intermediate.
+func (ca CARoot) rootCert() *x509.Certificate {
+	if ca.root != nil {
+		return ca.root
+	}
+	return ca.cert
 }
 
 // Exists reports if the root certificate exits.
@@ -157,6 +581,7 @@ func (ca CARoot) Exists() bool {
 
 // Load the root certificate from disk.
 func (ca *CARoot) Load() error {
+	ca.resolveDir()
 	if !ca.Exists() {
 		return errors.New("zcert.Load: CA certificate doesn't exist")
 	}
@@ -180,6 +605,22 @@ func (ca *CARoot) Load() error {
 	return nil
 }
 
+// Reload the root certificate from disk, for example after it was changed by
+// another process.
+//
+// The currently loaded root certificate is kept if this fails. Any tls.Config
+// created with TLSConfig() will still use the old root certificate and should
+// be re-created.
+func (ca *CARoot) Reload() error {
+	n := *ca
+	err := n.Load()
+	if err != nil {
+		return err
+	}
+	ca.cert, ca.key = n.cert, n.key
+	return nil
+}
+
 // Delete the root certificate.
 func (ca CARoot) Delete() error {
 	if !ca.Exists() {
@@ -192,8 +633,16 @@ func (ca CARoot) Delete() error {
 		return fmt.Errorf("zcert.Delete: %w", err)
 	}
 
-	err = os.Remove(rootKey)
-	if err != nil {
+	if rootKey != rootCert {
+		err = os.Remove(rootKey)
+		if err != nil {
+			return fmt.Errorf("zcert.Delete: %w", err)
+		}
+	}
+
+	// Revocations are meaningless for a new root.
+	err = os.Remove(ca.revokedPath())
+	if err != nil && !os.IsNotExist(err) {
 		return fmt.Errorf("zcert.Delete: %w", err)
 	}
 
@@ -206,31 +655,158 @@ func (ca CARoot) Delete() error {
 }
 
 // Install the root certificate to all truststores we can find.
+//
+// All stores are installed to concurrently, and a store failing doesn't affect
+// the others; use InstallStores() to see which stores failed. Commands that
+// need sudo or doas still run one at a time, so only one password prompt is
+// shown at once.
 func (ca CARoot) Install() error {
+	return ca.InstallContext(context.Background())
+}
+
+// InstallContext is like Install(), but stops when ctx is cancelled.
+//
+// Commands that the stores run, such as certutil or sudo waiting for a
+// password, are killed when ctx is cancelled, except for stores that don't
+// implement truststore.ContextStore, which are left to run in the background.
+func (ca CARoot) InstallContext(ctx context.Context) error {
+	_, err := ca.InstallStores(ctx)
+	return err
+}
+
+// StoreResult is the outcome of installing to a single trust store.
+type StoreResult struct {
+	Store string // Name of the store, from truststore.Store.Name().
+	Err   error  // Error, or nil if it was installed.
+}
+
+// Stages for CARoot.OnProgress.
+const (
+	StageInstall   = "install"   // Started installing to the store.
+	StageUninstall = "uninstall" // Started uninstalling from the store.
+	StageDone      = "done"      // Finished without errors.
+	StageFailed    = "failed"    // Finished with an error.
+)
+
+// InstallStores installs the root certificate to all truststores we can find,
+// and returns the outcome for every store it tried. It stops when ctx is
+// cancelled, as described in InstallContext().
+//
+// The error is a *Group with the errors of all stores that failed.
+func (ca CARoot) InstallStores(ctx context.Context) ([]StoreResult, error) {
 	if ca.cert == nil {
 		err := ca.Load()
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
-	stores := truststore.Find(ca.Verbose)
+	stores, err := truststore.FindStores(ca.storeOptions())
+	if err != nil {
+		return nil, fmt.Errorf("zcert.Install: %w", err)
+	}
 	if len(stores) == 0 {
-		return errors.New("no compatible truststores found")
+		return nil, errors.New("no compatible truststores found")
 	}
 
-	rootCert, _ := ca.StorePath()
-	errs := NewGroup(0)
-	for _, s := range stores {
-		fmt.Printf("Installing for %s...\n", s.Name())
-		errs.Append(s.Install(rootCert, ca.cert))
-		fmt.Println("  done")
+	rootCert, cleanup, err := ca.certFile()
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("zcert.Install: %w", ctx.Err())
+	}
+	return ca.eachStore(ctx, stores, StageInstall, func(ctx context.Context, s truststore.Store) error {
+		return truststore.InstallContext(ctx, s, rootCert, ca.cert)
+	})
+}
+
+// eachStore runs f for all stores concurrently, as most stores shell out to
+// slow tools such as keytool or certutil. It waits for all stores to finish.
+//
+// The results are in the same order as stores, and the error is a *Group
+// with the errors of all stores that failed.
+func (ca CARoot) eachStore(
+	ctx context.Context, stores []truststore.Store, stage string,
+	f func(context.Context, truststore.Store) error,
+) ([]StoreResult, error) {
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		results = make([]StoreResult, len(stores))
+		report  = func(store, stage string, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			ca.progress(store, stage, err)
+		}
+	)
+	for i, s := range stores {
+		i, s := i, s
+		report(s.Name(), stage, nil)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := ca.withTimeout(ctx, s, func(ctx context.Context) error { return f(ctx, s) })
+			results[i] = StoreResult{Store: s.Name(), Err: err}
+			if err != nil {
+				report(s.Name(), StageFailed, err)
+			} else {
+				report(s.Name(), StageDone, nil)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Add errors after everything finished, so the order doesn't depend on
+	// which store happened to be the fastest.
+	errs := NewGroup(ca.MaxErrors)
+	for _, r := range results {
+		errs.Append(r.Err)
+	}
+	return results, errs.ErrorOrNil()
+}
+
+func (ca CARoot) storeOptions() truststore.Options {
+	opts := ca.StoreOptions
+	opts.Verbose = opts.Verbose || ca.Verbose
+	return opts
+}
+
+// withTimeout runs f, giving up after StoreTimeout or when ctx is cancelled.
+//
+// The context passed to f is cancelled when it gives up, but f will keep
+// running in the background if it doesn't check it, as not all stores support
+// cancellation.
+func (ca CARoot) withTimeout(ctx context.Context, s truststore.Store, f func(context.Context) error) error {
+	parent := ctx
+	if ca.StoreTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, ca.StoreTimeout)
+		defer cancel()
+	}
+
+	ch := make(chan error, 1)
+	go func() { ch <- f(ctx) }()
+	select {
+	case err := <-ch:
+		return err
+	case <-ctx.Done():
+		if parent.Err() != nil {
+			return fmt.Errorf("%s: %w", s.Name(), parent.Err())
+		}
+		return fmt.Errorf("%s: timed out after %s; skipped", s.Name(), ca.StoreTimeout)
 	}
-	return errs.ErrorOrNil()
 }
 
 // Uninstall the root certificate from all truststores we can find.
 func (ca CARoot) Uninstall() error {
+	return ca.UninstallContext(context.Background())
+}
+
+// UninstallContext is like Uninstall(), but stops when ctx is cancelled, as
+// described in InstallContext().
+func (ca CARoot) UninstallContext(ctx context.Context) error {
 	if ca.cert == nil {
 		err := ca.Load()
 		if err != nil {
@@ -238,33 +814,378 @@ func (ca CARoot) Uninstall() error {
 		}
 	}
 
-	stores := truststore.Find(ca.Verbose)
+	stores, err := truststore.FindStores(ca.storeOptions())
+	if err != nil {
+		return fmt.Errorf("zcert.Uninstall: %w", err)
+	}
 	if len(stores) == 0 {
 		return errors.New("no compatible truststores found")
 	}
 
-	rootCert, _ := ca.StorePath()
-	errs := NewGroup(0)
-	for _, s := range stores {
-		fmt.Printf("Uninstalling for %s\n", s.Name())
-		errs.Append(s.Uninstall(rootCert, ca.cert))
+	rootCert, cleanup, err := ca.certFile()
+	if err != nil {
+		return err
 	}
-	return errs.ErrorOrNil()
+	defer cleanup()
+	if ctx.Err() != nil {
+		return fmt.Errorf("zcert.Uninstall: %w", ctx.Err())
+	}
+	_, err = ca.eachStore(ctx, stores, StageUninstall, func(ctx context.Context, s truststore.Store) error {
+		return truststore.UninstallContext(ctx, s, rootCert, ca.cert)
+	})
+	return err
+}
+
+// CertOptions are options for MakeCertOpts.
+type CertOptions struct {
+	Client bool // Create a client certificate.
+
+	// Set the certificate's Issuer to this, instead of the root certificate's
+	// Subject.
+	//
+	// WARNING: this creates a non-standard certificate that does *not* verify
+	// against the root certificate. It's intended for testing how clients
+	// deal with mismatched issuers only; never use it for anything else.
+	RawIssuer pkix.Name
+
+	// Add the addresses of all local network interfaces as IP SANs; loopback
+	// addresses are only added if AllIPsLoopback is also set.
+	AllIPs         bool
+	AllIPsLoopback bool
+
+	// Add localhost, 127.0.0.1, and ::1, in addition to any hosts that are
+	// given. No hosts need to be given if this is set.
+	Localhost bool
+
+	// Use this serial number instead of a random one.
+	//
+	// Serial numbers are supposed to be unique for every certificate a CA
+	// issues, and clients may reject certificates with a serial number they've
+	// already seen from the same issuer. This is intended for creating stable
+	// test fixtures only.
+	Serial *big.Int
+
+	// Skip hosts that can't be added as a SAN, and only return an error if
+	// none of them can. Without this an error is returned for any host that's
+	// not an IP, email, URI, or valid hostname.
+	RequireSAN bool
+
+	// Also add a wildcard for every DNS name, so that "example.com" is
+	// valid for "example.com" and "*.example.com". Names that are already a
+	// wildcard are left alone.
+	Wildcard bool
+
+	// For every wildcard DNS name, also add wildcards with more levels up to
+	// this depth; for example with 3 "*.example.com" also adds
+	// "*.*.example.com" and "*.*.*.example.com".
+	//
+	// Multi-level wildcards aren't part of any standard, and most clients
+	// (including browsers and Go) will reject them; it's only useful for
+	// clients that are known to accept them.
+	WildcardDepth int
+
+	// Subject for the certificate; any fields that are set override the
+	// defaults.
+	Subject pkix.Name
+
+	// Add a Netscape Comment extension with this text, which some
+	// certificate viewers show to help identify the certificate. This can
+	// only contain ASCII characters.
+	Comment string
+
+	// Add a Certificate Transparency SCT list extension with this many dummy
+	// SCTs; see DummySCTExtension().
+	DummySCTs int
+
+	// Called with the template after all other options are applied, to make
+	// any further changes.
+	CustomizeTemplate func(*x509.Certificate) error
+
+	// Add the root certificate after the certificate (and any intermediates),
+	// so clients that don't have the root installed get the full chain.
+	IncludeRoot bool
+
+	// Key usage and extended key usages for the certificate, instead of the
+	// defaults based on Client and the types of names. The defaults are used
+	// if these are 0 or nil.
+	KeyUsage    x509.KeyUsage
+	ExtKeyUsage []x509.ExtKeyUsage
 }
 
 // MakeCert creates a new certificate signed with the root certificate and
 // writes the PEM-encoded data to out.
 func (ca CARoot) MakeCert(out io.Writer, clientCert bool, hosts ...string) error {
+	return ca.MakeCertOpts(out, CertOptions{Client: clientCert}, hosts...)
+}
+
+// MakeCertOpts is like MakeCert, but allows setting more options.
+func (ca CARoot) MakeCertOpts(out io.Writer, opts CertOptions, hosts ...string) error {
+	return ca.MakeCertPair(out, out, opts, hosts...)
+}
+
+// MakeCertPair is like MakeCertOpts, but writes the PEM-encoded certificate
+// and private key to separate writers.
+func (ca CARoot) MakeCertPair(certOut, keyOut io.Writer, opts CertOptions, hosts ...string) error {
+	cert, privKey, err := ca.MakeCertTo(opts, hosts...)
+	if err != nil {
+		return err
+	}
+
+	privDER, err := x509.MarshalPKCS8PrivateKey(privKey)
+	if err != nil {
+		return fmt.Errorf("zcert.MakeCert: failed to encode certificate key: %w", err)
+	}
+
+	_, err = keyOut.Write(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER}))
+	if err != nil {
+		return fmt.Errorf("zcert.MakeCert: write private key: %w", err)
+	}
+	_, err = certOut.Write(ca.withChain(cert.Raw, opts.IncludeRoot))
+	if err != nil {
+		return fmt.Errorf("zcert.MakeCert: write certificate key: %w", err)
+	}
+
+	return nil
+}
+
+// MakeCertTo is like MakeCertOpts, but returns the parsed certificate and the
+// private key instead of writing them as PEM.
+//
+// IncludeRoot is ignored, as the returned certificate never includes the
+// chain; use Certificate() to get the root.
+func (ca CARoot) MakeCertTo(opts CertOptions, hosts ...string) (*x509.Certificate, crypto.PrivateKey, error) {
+	hosts = opts.withLocalhost(hosts)
+	if len(hosts) == 0 {
+		return nil, nil, errors.New("zcert.MakeCert: at least one host required")
+	}
+
+	if ca.cert == nil || ca.key == nil {
+		err := ca.Load()
+		if err != nil {
+			return nil, nil, fmt.Errorf("zcert.MakeCert: %w", err)
+		}
+	}
+
+	tpl, err := ca.template(opts, hosts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("zcert.MakeCert: %w", err)
+	}
+
+	privKey, err := ca.KeyAlgorithm.generate(ca.rand())
+	if err != nil {
+		return nil, nil, fmt.Errorf("zcert.MakeCert: generating private key: %w", err)
+	}
+	pubKey := privKey.(crypto.Signer).Public()
+
+	parent := ca.cert
+	if opts.RawIssuer.String() != "" {
+		p := *ca.cert
+		p.Subject, p.RawSubject = opts.RawIssuer, nil
+		parent = &p
+	}
+
+	der, err := x509.CreateCertificate(ca.rand(), tpl, parent, pubKey, ca.key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("zcert.MakeCert: generating certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, fmt.Errorf("zcert.MakeCert: %w", err)
+	}
+	return cert, privKey, nil
+}
+
+// withChain PEM-encodes the DER-encoded certificate, followed by any
+// intermediates, and the root if includeRoot is set.
+func (ca CARoot) withChain(cert []byte, includeRoot bool) []byte {
+	out := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})
+	for _, c := range ca.chain {
+		out = append(out, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})...)
+	}
+	if includeRoot {
+		out = append(out, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.rootCert().Raw})...)
+	}
+	return out
+}
+
+// MakeCertDER is like MakeCertOpts, but returns the DER-encoded certificate
+// and PKCS#8 private key.
+func (ca CARoot) MakeCertDER(opts CertOptions, hosts ...string) (cert, key []byte, err error) {
+	certBuf, keyBuf := new(bytes.Buffer), new(bytes.Buffer)
+	err = ca.MakeCertPair(certBuf, keyBuf, opts, hosts...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c, _ := pem.Decode(certBuf.Bytes())
+	k, _ := pem.Decode(keyBuf.Bytes())
+	if c == nil || k == nil {
+		return nil, nil, errors.New("zcert.MakeCertDER: decoding PEM data")
+	}
+	return c.Bytes, k.Bytes, nil
+}
+
+// SignCSROptions are options for SignCSR.
+type SignCSROptions struct {
+	// Copy the SANs and extended key usages requested in the CSR. If this
+	// isn't set only the CSR's CommonName is used as the hostname, and the
+	// same key usages as MakeCert are used.
+	CopyExtensions bool
+
+	// Extended key usages that may be copied from the CSR; SignCSR returns an
+	// error if the CSR requests anything else. The default is to allow only
+	// ServerAuth and ClientAuth.
+	AllowedEKU []x509.ExtKeyUsage
+}
+
+// SignCSR creates a certificate for the public key in the certificate signing
+// request, signed with the root certificate, and returns the PEM-encoded
+// certificate.
+//
+// Any Subject fields in the CSR override the defaults.
+func (ca CARoot) SignCSR(csr *x509.CertificateRequest, opts SignCSROptions) ([]byte, error) {
+	err := csr.CheckSignature()
+	if err != nil {
+		return nil, fmt.Errorf("zcert.SignCSR: %w", err)
+	}
+
 	if ca.cert == nil || ca.key == nil {
 		err := ca.Load()
 		if err != nil {
-			return fmt.Errorf("zcert.MakeCert: %w", err)
+			return nil, fmt.Errorf("zcert.SignCSR: %w", err)
+		}
+	}
+
+	var hosts []string
+	if opts.CopyExtensions {
+		hosts = append(hosts, csr.DNSNames...)
+		for _, ip := range csr.IPAddresses {
+			hosts = append(hosts, ip.String())
+		}
+		hosts = append(hosts, csr.EmailAddresses...)
+		for _, u := range csr.URIs {
+			hosts = append(hosts, u.String())
+		}
+	}
+	if len(hosts) == 0 && csr.Subject.CommonName != "" {
+		hosts = append(hosts, csr.Subject.CommonName)
+	}
+	if len(hosts) == 0 {
+		return nil, errors.New("zcert.SignCSR: no hostnames in CSR")
+	}
+
+	tpl, err := ca.template(CertOptions{}, hosts)
+	if err != nil {
+		return nil, fmt.Errorf("zcert.SignCSR: %w", err)
+	}
+	mergeName(&tpl.Subject, csr.Subject)
+
+	if opts.CopyExtensions {
+		eku, err := csrExtKeyUsage(csr)
+		if err != nil {
+			return nil, fmt.Errorf("zcert.SignCSR: %w", err)
+		}
+		allowed := opts.AllowedEKU
+		if len(allowed) == 0 {
+			allowed = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+		}
+		for _, u := range eku {
+			if !hasEKU(allowed, u) {
+				return nil, fmt.Errorf("zcert.SignCSR: extended key usage %s not allowed", ekuNames[u])
+			}
+		}
+		if len(eku) > 0 {
+			tpl.ExtKeyUsage = eku
+		}
+	}
+
+	cert, err := x509.CreateCertificate(ca.rand(), tpl, ca.cert, csr.PublicKey, ca.key)
+	if err != nil {
+		return nil, fmt.Errorf("zcert.SignCSR: generating certificate: %w", err)
+	}
+	return ca.withChain(cert, false), nil
+}
+
+var (
+	oidExtKeyUsage     = asn1.ObjectIdentifier{2, 5, 29, 37}
+	oidNetscapeComment = asn1.ObjectIdentifier{2, 16, 840, 1, 113730, 1, 13}
+
+	ekuOIDs = map[string]x509.ExtKeyUsage{
+		"1.3.6.1.5.5.7.3.1": x509.ExtKeyUsageServerAuth,
+		"1.3.6.1.5.5.7.3.2": x509.ExtKeyUsageClientAuth,
+		"1.3.6.1.5.5.7.3.3": x509.ExtKeyUsageCodeSigning,
+		"1.3.6.1.5.5.7.3.4": x509.ExtKeyUsageEmailProtection,
+		"1.3.6.1.5.5.7.3.8": x509.ExtKeyUsageTimeStamping,
+		"1.3.6.1.5.5.7.3.9": x509.ExtKeyUsageOCSPSigning,
+	}
+	ekuNames = map[x509.ExtKeyUsage]string{
+		x509.ExtKeyUsageServerAuth:      "serverAuth",
+		x509.ExtKeyUsageClientAuth:      "clientAuth",
+		x509.ExtKeyUsageCodeSigning:     "codeSigning",
+		x509.ExtKeyUsageEmailProtection: "emailProtection",
+		x509.ExtKeyUsageTimeStamping:    "timeStamping",
+		x509.ExtKeyUsageOCSPSigning:     "OCSPSigning",
+	}
+)
+
+// csrExtKeyUsage gets the extended key usages requested in the CSR.
+func csrExtKeyUsage(csr *x509.CertificateRequest) ([]x509.ExtKeyUsage, error) {
+	for _, ext := range csr.Extensions {
+		if !ext.Id.Equal(oidExtKeyUsage) {
+			continue
+		}
+
+		var oids []asn1.ObjectIdentifier
+		_, err := asn1.Unmarshal(ext.Value, &oids)
+		if err != nil {
+			return nil, fmt.Errorf("parsing extended key usage: %w", err)
+		}
+		eku := make([]x509.ExtKeyUsage, 0, len(oids))
+		for _, o := range oids {
+			u, ok := ekuOIDs[o.String()]
+			if !ok {
+				return nil, fmt.Errorf("unsupported extended key usage %s", o)
+			}
+			eku = append(eku, u)
+		}
+		return eku, nil
+	}
+	return nil, nil
+}
+
+func hasEKU(list []x509.ExtKeyUsage, u x509.ExtKeyUsage) bool {
+	for _, l := range list {
+		if l == u {
+			return true
 		}
 	}
+	return false
+}
 
-	serial, err := randomSerialNumber()
+// CertTemplate returns the template MakeCertOpts() would use to create a
+// certificate, without creating it.
+//
+// The public key is not set, as it's generated by MakeCertOpts().
+func (ca CARoot) CertTemplate(opts CertOptions, hosts ...string) (*x509.Certificate, error) {
+	hosts = opts.withLocalhost(hosts)
+	if len(hosts) == 0 {
+		return nil, errors.New("zcert.CertTemplate: at least one host required")
+	}
+	tpl, err := ca.template(opts, hosts)
 	if err != nil {
-		return fmt.Errorf("zcert.MakeCert: generating serial number: %w", err)
+		return nil, fmt.Errorf("zcert.CertTemplate: %w", err)
+	}
+	return tpl, nil
+}
+
+func (ca CARoot) template(opts CertOptions, hosts []string) (*x509.Certificate, error) {
+	serial := opts.Serial
+	if serial == nil {
+		var err error
+		serial, err = ca.serialNumber()
+		if err != nil {
+			return nil, fmt.Errorf("generating serial number: %w", err)
+		}
 	}
 
 	tpl := &x509.Certificate{
@@ -274,26 +1195,67 @@ func (ca CARoot) MakeCert(out io.Writer, clientCert bool, hosts ...string) error
 			OrganizationalUnit: []string{userAndHostname()},
 		},
 
-		NotAfter:  time.Now().AddDate(1, 0, 0),
-		NotBefore: time.Now(),
+		NotAfter:  ca.notAfter(),
+		NotBefore: ca.notBefore(),
 
 		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
 		BasicConstraintsValid: true,
+		OCSPServer:            ca.OCSPServer,
+	}
+
+	if opts.AllIPs {
+		ips, err := localIPs(opts.AllIPsLoopback)
+		if err != nil {
+			return nil, err
+		}
+		hosts = append(hosts, ips...)
 	}
 
+	var unknown []string
 	for _, h := range hosts {
 		if ip := net.ParseIP(h); ip != nil {
-			tpl.IPAddresses = append(tpl.IPAddresses, ip)
+			if !hasIP(tpl.IPAddresses, ip) {
+				tpl.IPAddresses = append(tpl.IPAddresses, ip)
+			}
 		} else if email, err := mail.ParseAddress(h); err == nil && email.Address == h {
 			tpl.EmailAddresses = append(tpl.EmailAddresses, h)
 		} else if uriName, err := url.Parse(h); err == nil && uriName.Scheme != "" && uriName.Host != "" {
 			tpl.URIs = append(tpl.URIs, uriName)
+		} else if name, err := idnaToASCII(h); err != nil {
+			return nil, fmt.Errorf("%q: %w", h, err)
+		} else if err := checkDNSName(name); err == nil {
+			tpl.DNSNames = append(tpl.DNSNames, name)
+		} else if opts.RequireSAN {
+			unknown = append(unknown, h)
 		} else {
-			tpl.DNSNames = append(tpl.DNSNames, h)
+			return nil, err
+		}
+	}
+	if opts.Wildcard {
+		names := make([]string, 0, len(tpl.DNSNames)*2)
+		for _, n := range tpl.DNSNames {
+			names = append(names, n)
+			if !strings.HasPrefix(n, "*.") && !hasString(tpl.DNSNames, "*."+n) {
+				names = append(names, "*."+n)
+			}
 		}
+		tpl.DNSNames = names
+	}
+	if opts.WildcardDepth > 1 {
+		for _, n := range tpl.DNSNames {
+			if !strings.HasPrefix(n, "*.") || strings.HasPrefix(n, "*.*.") {
+				continue
+			}
+			for i := 2; i <= opts.WildcardDepth; i++ {
+				tpl.DNSNames = append(tpl.DNSNames, strings.Repeat("*.", i)+n[2:])
+			}
+		}
+	}
+	if opts.RequireSAN && len(tpl.DNSNames)+len(tpl.IPAddresses)+len(tpl.EmailAddresses)+len(tpl.URIs) == 0 {
+		return nil, fmt.Errorf("no valid SANs; couldn't use any of: %q", unknown)
 	}
 
-	if clientCert {
+	if opts.Client {
 		tpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth}
 		tpl.Subject.CommonName = hosts[0]
 	} else if len(tpl.IPAddresses) > 0 || len(tpl.DNSNames) > 0 {
@@ -302,55 +1264,201 @@ func (ca CARoot) MakeCert(out io.Writer, clientCert bool, hosts ...string) error
 	if len(tpl.EmailAddresses) > 0 {
 		tpl.ExtKeyUsage = append(tpl.ExtKeyUsage, x509.ExtKeyUsageCodeSigning, x509.ExtKeyUsageEmailProtection)
 	}
-
-	privKey, err := generateKey()
-	if err != nil {
-		return fmt.Errorf("zcert.MakeCert: generating private key: %w", err)
+	if opts.KeyUsage != 0 {
+		tpl.KeyUsage = opts.KeyUsage
 	}
-	pubKey := privKey.(crypto.Signer).Public()
-
-	cert, err := x509.CreateCertificate(rand.Reader, tpl, ca.cert, pubKey, ca.key)
-	if err != nil {
-		return fmt.Errorf("zcert.MakeCert: generating certificate: %w", err)
+	if opts.ExtKeyUsage != nil {
+		tpl.ExtKeyUsage = opts.ExtKeyUsage
 	}
+	mergeName(&tpl.Subject, pkix.Name{
+		Organization:       ca.Subject.Organization,
+		OrganizationalUnit: ca.Subject.OrganizationalUnit,
+	})
+	mergeName(&tpl.Subject, opts.Subject)
 
-	privDER, err := x509.MarshalPKCS8PrivateKey(privKey)
-	if err != nil {
-		return fmt.Errorf("zcert.MakeCert: failed to encode certificate key: %w", err)
+	if opts.Comment != "" {
+		// The extension is an IA5String, which can only contain ASCII.
+		if !isASCII(opts.Comment) {
+			return nil, fmt.Errorf("comment %q: can only contain ASCII characters", opts.Comment)
+		}
+		v, err := asn1.MarshalWithParams(opts.Comment, "ia5")
+		if err != nil {
+			return nil, fmt.Errorf("encoding comment: %w", err)
+		}
+		tpl.ExtraExtensions = append(tpl.ExtraExtensions, pkix.Extension{Id: oidNetscapeComment, Value: v})
 	}
+	if opts.DummySCTs > 0 {
+		ext, err := dummySCTExtension(ca.rand(), ca.now(), opts.DummySCTs)
+		if err != nil {
+			return nil, err
+		}
+		tpl.ExtraExtensions = append(tpl.ExtraExtensions, ext)
+	}
+	if opts.CustomizeTemplate != nil {
+		err := opts.CustomizeTemplate(tpl)
+		if err != nil {
+			return nil, fmt.Errorf("CustomizeTemplate: %w", err)
+		}
+	}
+	return tpl, nil
+}
 
-	_, err = out.Write(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER}))
-	if err != nil {
-		return fmt.Errorf("zcert.MakeCert: write private key: %w", err)
+// mergeName sets all non-empty fields from src on dst.
+func mergeName(dst *pkix.Name, src pkix.Name) {
+	set := func(d *[]string, s []string) {
+		if len(s) > 0 {
+			*d = s
+		}
 	}
-	_, err = out.Write(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}))
-	if err != nil {
-		return fmt.Errorf("zcert.MakeCert: write certificate key: %w", err)
+	set(&dst.Country, src.Country)
+	set(&dst.Organization, src.Organization)
+	set(&dst.OrganizationalUnit, src.OrganizationalUnit)
+	set(&dst.Locality, src.Locality)
+	set(&dst.Province, src.Province)
+	set(&dst.StreetAddress, src.StreetAddress)
+	set(&dst.PostalCode, src.PostalCode)
+	if src.SerialNumber != "" {
+		dst.SerialNumber = src.SerialNumber
 	}
+	if src.CommonName != "" {
+		dst.CommonName = src.CommonName
+	}
+}
 
-	return nil
+// TLSConfigOptions are options for TLSConfigOpts.
+type TLSConfigOptions struct {
+	MinVersion uint16 // Minimum TLS version; uses Go's default if 0.
+	MaxVersion uint16 // Maximum TLS version; uses Go's default if 0.
+
+	// Keep at most this many certificates in the cache, dropping the least
+	// recently used one when it's full. The default of 0 means no limit, which
+	// may use a lot of memory when clients send many different hostnames.
+	CacheSize int
 }
 
 // TLSConfig returns a new tls.Config which creates certificates for any
 // hostname.
 func (ca CARoot) TLSConfig() *tls.Config {
-	certs := make(map[string]*tls.Certificate)
-	tlsc := new(tls.Config)
+	return ca.TLSConfigOpts(TLSConfigOptions{})
+}
+
+// TLSConfigOpts is like TLSConfig, but allows setting more options.
+func (ca CARoot) TLSConfigOpts(opts TLSConfigOptions) *tls.Config {
+	certs := newCertCache(opts.CacheSize)
+	tlsc := &tls.Config{
+		MinVersion: opts.MinVersion,
+		MaxVersion: opts.MaxVersion,
+	}
+	// GetCertificate is called concurrently for every handshake.
 	tlsc.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
-		c, ok := certs[hello.ServerName]
-		if !ok {
-			var err error
-			c, err = ca.MakeTLSCert(false, hello.ServerName)
-			if err != nil {
-				return nil, err
-			}
-			certs[hello.ServerName] = c
+		if c := certs.get(hello.ServerName); c != nil {
+			return c, nil
+		}
+
+		// Don't hold the lock while creating the certificate, as that's slow.
+		// Concurrent handshakes may create it more than once; the first one is
+		// kept.
+		c, err := ca.MakeTLSCert(false, hello.ServerName)
+		if err != nil {
+			return nil, err
 		}
-		return c, nil
+		return certs.add(hello.ServerName, c), nil
 	}
 	return tlsc
 }
 
+// WarmCache creates the certificates for hosts with the GetCertificate
+// function of a tls.Config from TLSConfig(), so that the first connections for
+// them don't have to wait for the key to be generated.
+func WarmCache(tlsc *tls.Config, hosts ...string) error {
+	if tlsc.GetCertificate == nil {
+		return errors.New("zcert.WarmCache: GetCertificate not set")
+	}
+	for _, h := range hosts {
+		_, err := tlsc.GetCertificate(&tls.ClientHelloInfo{ServerName: h})
+		if err != nil {
+			return fmt.Errorf("zcert.WarmCache: %s: %w", h, err)
+		}
+	}
+	return nil
+}
+
+// certCache is a cache of certificates by hostname, safe for concurrent use.
+type certCache struct {
+	mu    sync.Mutex
+	max   int                      // Maximum size; 0 is no limit.
+	ll    *list.List               // Most recently used at the front.
+	certs map[string]*list.Element // Values are *certCacheEntry.
+}
+
+type certCacheEntry struct {
+	host string
+	cert *tls.Certificate
+}
+
+func newCertCache(max int) *certCache {
+	return &certCache{max: max, ll: list.New(), certs: make(map[string]*list.Element)}
+}
+
+// get a certificate; returns nil if it's not in the cache.
+func (c *certCache) get(host string) *tls.Certificate {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.certs[host]
+	if !ok {
+		return nil
+	}
+	c.ll.MoveToFront(e)
+	return e.Value.(*certCacheEntry).cert
+}
+
+// add a certificate, unless there already is one for host. The certificate
+// that's in the cache is returned.
+func (c *certCache) add(host string, cert *tls.Certificate) *tls.Certificate {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.certs[host]; ok {
+		c.ll.MoveToFront(e)
+		return e.Value.(*certCacheEntry).cert
+	}
+
+	c.certs[host] = c.ll.PushFront(&certCacheEntry{host: host, cert: cert})
+	if c.max > 0 && c.ll.Len() > c.max {
+		last := c.ll.Back()
+		c.ll.Remove(last)
+		delete(c.certs, last.Value.(*certCacheEntry).host)
+	}
+	return cert
+}
+
+func (c *certCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+// LocalhostTLSConfig returns a tls.Config with a single certificate for
+// localhost, 127.0.0.1, and ::1.
+//
+// This uses the root certificate from New(), or a temporary in-memory root if
+// that fails (e.g. if there is no writable location to store it).
+func LocalhostTLSConfig() (*tls.Config, error) {
+	ca, _, err := New()
+	if err != nil {
+		ca = CARoot{}
+		ca.cert, ca.key, err = ca.generate()
+		if err != nil {
+			return nil, fmt.Errorf("zcert.LocalhostTLSConfig: %w", err)
+		}
+	}
+
+	cert, err := ca.MakeTLSCert(false, "localhost", "127.0.0.1", "::1")
+	if err != nil {
+		return nil, fmt.Errorf("zcert.LocalhostTLSConfig: %w", err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{*cert}}, nil
+}
+
 // MakeTLS creates a new TLS certificate signed with the root certificate.
 func (ca CARoot) MakeTLSCert(clientCert bool, hosts ...string) (*tls.Certificate, error) {
 	out := new(bytes.Buffer)
@@ -366,11 +1474,120 @@ func (ca CARoot) MakeTLSCert(clientCert bool, hosts ...string) (*tls.Certificate
 	return &t, nil
 }
 
-// StorePaths gets the full path name to the root certificate. Returns
+// NewMTLSPair creates a server and client certificate for testing mutual TLS,
+// and a pool with the root certificate for both sides to verify against.
+func NewMTLSPair(ca CARoot, serverHosts, clientName []string) (serverCert, clientCert *tls.Certificate, pool *x509.CertPool, err error) {
+	if ca.cert == nil || ca.key == nil {
+		err := ca.Load()
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("zcert.NewMTLSPair: %w", err)
+		}
+	}
+
+	serverCert, err = ca.MakeTLSCert(false, serverHosts...)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("zcert.NewMTLSPair: server certificate: %w", err)
+	}
+	clientCert, err = ca.MakeTLSCert(true, clientName...)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("zcert.NewMTLSPair: client certificate: %w", err)
+	}
+
+	pool = x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return serverCert, clientCert, pool, nil
+}
+
+// KeyMatchesCert reports if the private key belongs to the public key in cert.
+//
+// This works for all key types supported by crypto/x509: ECDSA, RSA, and
+// Ed25519.
+func KeyMatchesCert(cert *x509.Certificate, key crypto.PrivateKey) bool {
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return false
+	}
+	have, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return false
+	}
+	want, err := x509.MarshalPKIXPublicKey(cert.PublicKey)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(have, want)
+}
+
+// StorePath gets the full path name to the root certificate. Returns
 // certificate and key.
-func (CARoot) StorePath() (string, string) {
+//
+// Both are the same file if the root is stored in a single file; this is the
+// case if rootCA.pem exists without rootCA-key.pem, or if SingleFile is set
+// and neither exists yet.
+func (ca CARoot) StorePath() (string, string) {
+	dir, _ := ca.storeDir()
+	if dir == "" {
+		return "", ""
+	}
+
+	rootCert, rootKey := filepath.Join(dir, "rootCA.pem"), filepath.Join(dir, "rootCA-key.pem")
+	if pathExists(rootKey) {
+		return rootCert, rootKey
+	}
+	if ca.SingleFile || pathExists(rootCert) {
+		return rootCert, rootCert
+	}
+	return rootCert, rootKey
+}
+
+// certFile gets the path to a file with just the root certificate, for the
+// trust stores. If the key is stored in the same file this writes the
+// certificate to a temporary file, which is removed by the returned function.
+func (ca CARoot) certFile() (string, func(), error) {
+	rootCert, rootKey := ca.StorePath()
+	if rootCert != rootKey {
+		return rootCert, func() {}, nil
+	}
+
+	fp, err := ioutil.TempFile("", "zcert-rootCA-*.pem")
+	if err != nil {
+		return "", nil, fmt.Errorf("zcert.certFile: %w", err)
+	}
+	cleanup := func() { os.Remove(fp.Name()) }
+	err = pem.Encode(fp, &pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})
+	if err2 := fp.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("zcert.certFile: %w", err)
+	}
+	return fp.Name(), cleanup, nil
+}
+
+// storeDir gets the directory to store the root certificate in; the second
+// return value reports if this is the fallback cache directory.
+func (ca CARoot) storeDir() (string, bool) {
+	if ca.dir != "" {
+		return ca.dir, ca.dirFallback
+	}
+	return ca.findStoreDir()
+}
+
+// resolveDir finds the store directory once, so that hot paths don't need to
+// check if the directory is writable on every call.
+func (ca *CARoot) resolveDir() {
+	if ca.dir == "" {
+		ca.dir, ca.dirFallback = ca.findStoreDir()
+	}
+}
+
+func (ca CARoot) findStoreDir() (string, bool) {
 	var dir string
 	switch {
+	case ca.Dir != "":
+		dir = ca.Dir
+
 	case os.Getenv("CAROOT") != "":
 		dir = os.Getenv("CAROOT")
 
@@ -383,24 +1600,98 @@ func (CARoot) StorePath() (string, string) {
 	case runtime.GOOS == "darwin":
 		dir = os.Getenv("HOME")
 		if dir == "" {
-			return "", ""
+			return "", false
 		}
 		dir = filepath.Join(dir, "Library", "Application Support")
 
 	default: // Unix
 		dir = os.Getenv("HOME")
 		if dir == "" {
-			return "", ""
+			return "", false
 		}
 		dir = filepath.Join(dir, ".local", "share")
 	}
 	if dir == "" {
-		return "", ""
+		return "", false
 	}
-
-	// TODO: store in single file?
 	dir = filepath.Join(dir, "zcert")
-	return filepath.Join(dir, "rootCA.pem"), filepath.Join(dir, "rootCA-key.pem")
+
+	if !ca.CacheFallback || pathExists(filepath.Join(dir, "rootCA.pem")) || writable(dir) {
+		return dir, false
+	}
+
+	cache, err := os.UserCacheDir()
+	if err != nil || !writable(filepath.Join(cache, "zcert")) {
+		cache = os.TempDir()
+	}
+	return filepath.Join(cache, "zcert"), true
+}
+
+// progress calls OnProgress, or prints the progress if it's not set.
+func (ca CARoot) progress(store, stage string, err error) {
+	if ca.OnProgress != nil {
+		ca.OnProgress(store, stage, err)
+		return
+	}
+	switch stage {
+	case StageInstall:
+		ca.printf("Installing for %s...\n", store)
+	case StageUninstall:
+		ca.printf("Uninstalling for %s...\n", store)
+	default:
+		ca.printf("  %s: %s\n", store, stage)
+	}
+}
+
+func (ca CARoot) warn(format string, a ...interface{}) {
+	if ca.OnWarning != nil {
+		ca.OnWarning(fmt.Sprintf(format, a...))
+	}
+}
+
+func (ca CARoot) printf(format string, a ...interface{}) {
+	if !ca.Quiet {
+		fmt.Printf(format, a...)
+	}
+}
+
+// now gets the current time from Now, or time.Now() if it's not set.
+func (ca CARoot) now() time.Time {
+	if ca.Now != nil {
+		return ca.Now()
+	}
+	return time.Now()
+}
+
+func (ca CARoot) rootNotAfter() time.Time {
+	if ca.RootValidity > 0 {
+		return ca.now().Add(ca.RootValidity)
+	}
+	return ca.now().AddDate(10, 0, 0)
+}
+
+func (ca CARoot) notAfter() time.Time {
+	n := ca.now().AddDate(1, 0, 0)
+	if ca.Validity > 0 {
+		n = ca.now().Add(ca.Validity)
+	}
+	if ca.cert != nil && n.After(ca.cert.NotAfter) && !ca.AllowLongerThanRoot {
+		ca.warn("validity is longer than the root certificate's; using the root's expiry of %s",
+			ca.cert.NotAfter.Format("2006-01-02"))
+		n = ca.cert.NotAfter
+	}
+	return n
+}
+
+func (ca CARoot) notBefore() time.Time {
+	skew := ca.NotBeforeSkew
+	switch {
+	case skew == 0:
+		skew = time.Minute
+	case skew < 0:
+		skew = 0
+	}
+	return ca.now().Add(-skew)
 }
 
 var (
@@ -431,10 +1722,123 @@ func pathExists(path string) bool {
 	return err == nil
 }
 
-func randomSerialNumber() (*big.Int, error) {
-	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+// writable reports if we can create files in dir, or in the first parent
+// directory that exists if dir doesn't exist yet.
+func writable(dir string) bool {
+	for !pathExists(dir) {
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return false
+		}
+		dir = parent
+	}
+
+	fp, err := ioutil.TempFile(dir, ".zcert-")
+	if err != nil {
+		return false
+	}
+	fp.Close()
+	os.Remove(fp.Name())
+	return true
+}
+
+// localIPs gets the IP addresses of all local network interfaces, excluding
+// link-local addresses.
+func localIPs(loopback bool) ([]string, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+
+	ips := make([]string, 0, len(addrs))
+	for _, a := range addrs {
+		n, ok := a.(*net.IPNet)
+		if !ok || n.IP.IsLinkLocalUnicast() || (!loopback && n.IP.IsLoopback()) {
+			continue
+		}
+		ips = append(ips, n.IP.String())
+	}
+	return ips, nil
+}
+
+// withLocalhost adds the localhost names to hosts if Localhost is set, unless
+// they're already in there.
+func (opts CertOptions) withLocalhost(hosts []string) []string {
+	if !opts.Localhost {
+		return hosts
+	}
+	l := append(make([]string, 0, len(hosts)+3), hosts...)
+	for _, h := range []string{"localhost", "127.0.0.1", "::1"} {
+		if !hasString(l, h) {
+			l = append(l, h)
+		}
+	}
+	return l
+}
+
+func hasString(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+func hasIP(ips []net.IP, ip net.IP) bool {
+	for _, i := range ips {
+		if i.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkDNSName checks if h looks like a hostname: a list of labels with
+// letters, digits, "-", and "_", optionally starting with a "*" wildcard.
+func checkDNSName(h string) error {
+	n := strings.TrimPrefix(strings.TrimSuffix(h, "."), "*.")
+	if n == "" {
+		return fmt.Errorf("invalid hostname %q: empty", h)
+	}
+	if len(n) > 253 {
+		return fmt.Errorf("invalid hostname %q: longer than 253 characters", h)
+	}
+	for _, l := range strings.Split(n, ".") {
+		if l == "" {
+			return fmt.Errorf("invalid hostname %q: empty label", h)
+		}
+		if len(l) > 63 {
+			return fmt.Errorf("invalid hostname %q: label %q is longer than 63 characters", h, l)
+		}
+		for _, c := range l {
+			switch {
+			case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_':
+			case c == '*':
+				return fmt.Errorf("invalid hostname %q: a wildcard is only allowed as the leftmost label", h)
+			case c == ' ':
+				return fmt.Errorf("invalid hostname %q: contains a space", h)
+			case c < 0x20 || c == 0x7f:
+				return fmt.Errorf("invalid hostname %q: contains control character %U", h, c)
+			default:
+				return fmt.Errorf("invalid hostname %q: invalid character %q", h, c)
+			}
+		}
+	}
+	return nil
 }
 
-func generateKey() (crypto.PrivateKey, error) {
-	return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+func (ca CARoot) serialNumber() (*big.Int, error) {
+	if ca.SerialNumber != nil {
+		return ca.SerialNumber()
+	}
+	return rand.Int(ca.rand(), new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// rand gets the source of randomness from Rand.
+func (ca CARoot) rand() io.Reader {
+	if ca.Rand != nil {
+		return ca.Rand
+	}
+	return rand.Reader
 }
@@ -1,11 +1,38 @@
 package zcert
 
 import (
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
 	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"math/big"
+	mrand "math/rand"
+	"net"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"zgo.at/zcert/truststore"
 )
 
 func TestCARoot(t *testing.T) {
@@ -85,3 +112,1587 @@ func TestCARoot(t *testing.T) {
 
 	// TODO: test with HTTP server?
 }
+
+func TestDir(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "zcert-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	old, ok := os.LookupEnv("CAROOT")
+	os.Setenv("CAROOT", "/dev/null/zcert")
+	defer func() {
+		if ok {
+			os.Setenv("CAROOT", old)
+		} else {
+			os.Unsetenv("CAROOT")
+		}
+	}()
+
+	root := CARoot{Dir: tmp, Quiet: true}
+	if have, _ := root.StorePath(); have != filepath.Join(tmp, "zcert", "rootCA.pem") {
+		t.Errorf("wrong StorePath: %s", have)
+	}
+	err = root.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !(CARoot{Dir: tmp}).Exists() {
+		t.Error("root doesn't exist")
+	}
+}
+
+func TestImport(t *testing.T) {
+	src := newTestRoot(t)
+	certFile, keyFile := src.StorePath()
+	certPEM, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf := new(bytes.Buffer)
+	err = src.MakeCert(leaf, false, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := new(bytes.Buffer)
+	err = newTestRoot(t).MakeCert(other, false, "example.com") // Different key.
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmp, err := ioutil.TempDir("", "zcert-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	tests := []struct {
+		cert, key []byte
+		wantErr   string
+	}{
+		{leaf.Bytes(), leaf.Bytes(), "not a CA certificate"},
+		{certPEM, other.Bytes(), "private key does not match"},
+		{certPEM, keyPEM, ""},
+		{certPEM, keyPEM, "already exists"},
+	}
+	for _, tt := range tests {
+		root := CARoot{Dir: tmp}
+		err := root.Import(tt.cert, tt.key)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !root.Certificate().Equal(src.Certificate()) {
+				t.Error("wrong certificate")
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("\nhave: %v\nwant: %s", err, tt.wantErr)
+		}
+	}
+
+	root := CARoot{Dir: tmp}
+	err = root.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !root.Certificate().Equal(src.Certificate()) {
+		t.Error("wrong certificate after Load()")
+	}
+}
+
+func TestSingleFile(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "zcert-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	root := CARoot{Dir: tmp, SingleFile: true}
+	err = root.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := root.StorePath()
+	if certFile != keyFile {
+		t.Errorf("different files: %q, %q", certFile, keyFile)
+	}
+	if pathExists(filepath.Join(tmp, "zcert", "rootCA-key.pem")) {
+		t.Error("rootCA-key.pem exists")
+	}
+
+	// Layout is detected without SingleFile.
+	loaded := CARoot{Dir: tmp}
+	err = loaded.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.Certificate().Equal(root.Certificate()) {
+		t.Error("wrong certificate after Load()")
+	}
+	err = loaded.MakeCert(new(bytes.Buffer), false, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = loaded.Delete()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Exists() {
+		t.Error("still exists after Delete()")
+	}
+
+	// Existing two-file roots are still loaded from two files.
+	two := CARoot{Dir: tmp}
+	err = two.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded = CARoot{Dir: tmp, SingleFile: true}
+	if certFile, keyFile := loaded.StorePath(); certFile == keyFile {
+		t.Errorf("same file: %q", certFile)
+	}
+	err = loaded.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.Certificate().Equal(two.Certificate()) {
+		t.Error("wrong certificate after Load()")
+	}
+}
+
+func TestNotBefore(t *testing.T) {
+	tests := []struct {
+		skew time.Duration
+		want time.Duration
+	}{
+		{0, time.Minute},
+		{-1, 0},
+		{time.Hour, time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.skew.String(), func(t *testing.T) {
+			got := time.Since(CARoot{NotBeforeSkew: tt.skew}.notBefore()).Round(time.Second)
+			if got != tt.want {
+				t.Errorf("got %s; want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidity(t *testing.T) {
+	root := newTestRoot(t)
+	var warned bool
+	root.OnWarning = func(string) { warned = true }
+
+	tests := []struct {
+		validity time.Duration
+		longer   bool
+		want     time.Time
+		warn     bool
+	}{
+		{0, false, time.Now().AddDate(1, 0, 0), false},
+		{7 * 24 * time.Hour, false, time.Now().Add(7 * 24 * time.Hour), false},
+		{100 * 365 * 24 * time.Hour, false, root.Certificate().NotAfter, true},
+		{100 * 365 * 24 * time.Hour, true, time.Now().Add(100 * 365 * 24 * time.Hour), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s-%t", tt.validity, tt.longer), func(t *testing.T) {
+			warned = false
+			root.Validity, root.AllowLongerThanRoot = tt.validity, tt.longer
+			tpl, err := root.CertTemplate(CertOptions{}, "example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d := tpl.NotAfter.Sub(tt.want); d > time.Second || d < -time.Second {
+				t.Errorf("\nhave: %s\nwant: %s", tpl.NotAfter, tt.want)
+			}
+			if warned != tt.warn {
+				t.Errorf("warned: %t", warned)
+			}
+		})
+	}
+}
+
+func TestRootValidity(t *testing.T) {
+	root := newTestRootOpts(t, CARoot{RootValidity: 30 * 24 * time.Hour})
+	want := time.Now().Add(30 * 24 * time.Hour)
+	if d := root.Certificate().NotAfter.Sub(want); d > time.Second || d < -time.Second {
+		t.Errorf("\nhave: %s\nwant: %s", root.Certificate().NotAfter, want)
+	}
+
+	// Loading doesn't depend on RootValidity.
+	err := (&CARoot{}).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewMTLSPair(t *testing.T) {
+	root := newTestRoot(t)
+
+	serverCert, clientCert, pool, err := NewMTLSPair(root, []string{"example.localhost"}, []string{"client"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sConn, cConn := net.Pipe()
+	server := tls.Server(sConn, &tls.Config{
+		Certificates: []tls.Certificate{*serverCert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})
+	client := tls.Client(cConn, &tls.Config{
+		Certificates: []tls.Certificate{*clientCert},
+		RootCAs:      pool,
+		ServerName:   "example.localhost",
+	})
+
+	defer sConn.Close()
+	defer cConn.Close()
+
+	errs := make(chan error, 1)
+	go func() { errs <- server.Handshake() }()
+	err = client.Handshake()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newTestRoot creates a new root certificate in a temporary directory.
+func newTestRoot(t *testing.T) CARoot {
+	t.Helper()
+	return newTestRootOpts(t, CARoot{})
+}
+
+// newTestRootOpts creates a new root in a temporary CAROOT, using the options
+// from root.
+func newTestRootOpts(t *testing.T, root CARoot) CARoot {
+	t.Helper()
+
+	tmp, err := ioutil.TempDir("", "zcert-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(tmp) })
+
+	old, ok := os.LookupEnv("CAROOT")
+	os.Setenv("CAROOT", tmp)
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv("CAROOT", old)
+		} else {
+			os.Unsetenv("CAROOT")
+		}
+	})
+
+	err = root.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func TestRawIssuer(t *testing.T) {
+	root := newTestRoot(t)
+
+	buf := new(bytes.Buffer)
+	err := root.MakeCertOpts(buf, CertOptions{
+		RawIssuer: pkix.Name{CommonName: "Not the zcert root"},
+	}, "example.localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := tls.X509KeyPair(buf.Bytes(), buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if have, want := c.Issuer.String(), "CN=Not the zcert root"; have != want {
+		t.Errorf("\nhave: %s\nwant: %s", have, want)
+	}
+	if err := c.CheckSignatureFrom(root.Certificate()); err != nil {
+		t.Errorf("signature doesn't verify: %s", err)
+	}
+}
+
+func TestMakeCertNoHosts(t *testing.T) {
+	root := newTestRoot(t)
+
+	for _, client := range []bool{false, true} {
+		t.Run(fmt.Sprintf("client=%t", client), func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			err := root.MakeCert(buf, client)
+			if err == nil {
+				t.Fatal("err is nil")
+			}
+			if have, want := err.Error(), "zcert.MakeCert: at least one host required"; have != want {
+				t.Errorf("\nhave: %s\nwant: %s", have, want)
+			}
+			if buf.Len() > 0 {
+				t.Errorf("wrote output: %q", buf.String())
+			}
+		})
+	}
+}
+
+func TestGroupMaxSize(t *testing.T) {
+	errs := NewGroup(2)
+	for i := 0; i < 5; i++ {
+		errs.Append(fmt.Errorf("err %d", i))
+	}
+
+	if have, want := errs.Error(), "err 0\nerr 1\n(and 3 more)\n"; have != want {
+		t.Errorf("\nhave: %q\nwant: %q", have, want)
+	}
+}
+
+func TestGroupIs(t *testing.T) {
+	errs := NewGroup(0)
+	errs.Append(errors.New("first"))
+	errs.Append(fmt.Errorf("store: %w", &os.PathError{Op: "open", Path: "/x", Err: os.ErrNotExist}))
+	err := fmt.Errorf("wrapped: %w", errs.ErrorOrNil())
+
+	if have := len(errs.Errors()); have != 2 {
+		t.Errorf("len(Errors()) = %d", have)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Error("errors.Is(os.ErrNotExist) is false")
+	}
+	if errors.Is(err, os.ErrExist) {
+		t.Error("errors.Is(os.ErrExist) is true")
+	}
+	var pErr *os.PathError
+	if !errors.As(err, &pErr) || pErr.Path != "/x" {
+		t.Errorf("errors.As(*os.PathError): %v", pErr)
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	makeCert := func(root CARoot) *x509.Certificate {
+		t.Helper()
+		c, err := root.MakeTLSCert(false, "example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		pc, err := x509.ParseCertificate(c.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pc
+	}
+
+	other := makeCert(newTestRoot(t))
+	root := newTestRoot(t)
+	leaf := makeCert(root)
+
+	err := root.Revoke(other)
+	if err == nil || !strings.Contains(err.Error(), "not signed by the root") {
+		t.Errorf("wrong error: %v", err)
+	}
+	for i := 0; i < 2; i++ { // Revoking twice is fine.
+		err = root.Revoke(leaf)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	der, err := root.GenerateCRL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	crl, err := x509.ParseCRL(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = root.Certificate().CheckCRLSignature(crl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc := crl.TBSCertList.RevokedCertificates
+	if len(rc) != 1 || rc[0].SerialNumber.Cmp(leaf.SerialNumber) != 0 {
+		t.Errorf("wrong revoked certificates: %v", rc)
+	}
+
+	err = root.Delete()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pathExists(root.revokedPath()) {
+		t.Error("revoked.json still exists after Delete()")
+	}
+}
+
+func TestCertTemplate(t *testing.T) {
+	var root CARoot
+	tpl, err := root.CertTemplate(CertOptions{Client: true}, "example.com", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if have, want := fmt.Sprint(tpl.DNSNames, tpl.IPAddresses), "[example.com] [127.0.0.1]"; have != want {
+		t.Errorf("\nhave: %s\nwant: %s", have, want)
+	}
+	if have, want := tpl.Subject.CommonName, "example.com"; have != want {
+		t.Errorf("\nhave: %s\nwant: %s", have, want)
+	}
+
+	_, err = root.CertTemplate(CertOptions{})
+	if err == nil {
+		t.Error("no error for empty hosts")
+	}
+}
+
+func TestKeyUsage(t *testing.T) {
+	var root CARoot
+	tpl, err := root.CertTemplate(CertOptions{}, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tpl.KeyUsage != x509.KeyUsageKeyEncipherment|x509.KeyUsageDigitalSignature {
+		t.Errorf("wrong default KeyUsage: %v", tpl.KeyUsage)
+	}
+	if have, want := fmt.Sprint(tpl.ExtKeyUsage), fmt.Sprint([]x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}); have != want {
+		t.Errorf("wrong default ExtKeyUsage\nhave: %s\nwant: %s", have, want)
+	}
+
+	tpl, err = root.CertTemplate(CertOptions{
+		Client:      true,
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tpl.KeyUsage != x509.KeyUsageDigitalSignature {
+		t.Errorf("wrong KeyUsage: %v", tpl.KeyUsage)
+	}
+	if have, want := fmt.Sprint(tpl.ExtKeyUsage), fmt.Sprint([]x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning}); have != want {
+		t.Errorf("wrong ExtKeyUsage\nhave: %s\nwant: %s", have, want)
+	}
+}
+
+func TestLocalhost(t *testing.T) {
+	var root CARoot
+	tests := []struct {
+		hosts []string
+		want  string
+	}{
+		{nil, "[localhost] [127.0.0.1 ::1]"},
+		{[]string{"example.com"}, "[example.com localhost] [127.0.0.1 ::1]"},
+		{[]string{"127.0.0.1", "localhost"}, "[localhost] [127.0.0.1 ::1]"},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.hosts), func(t *testing.T) {
+			tpl, err := root.CertTemplate(CertOptions{Localhost: true}, tt.hosts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if have := fmt.Sprint(tpl.DNSNames, tpl.IPAddresses); have != tt.want {
+				t.Errorf("\nhave: %s\nwant: %s", have, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyMatchesCert(t *testing.T) {
+	root := newTestRoot(t)
+
+	for _, alg := range []KeyAlgorithm{ECDSAP256, ECDSAP384, RSA2048, Ed25519} {
+		t.Run(alg.String(), func(t *testing.T) {
+			root.KeyAlgorithm = alg
+			c, key, err := root.MakeCertTo(CertOptions{}, "a.example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, other, err := root.MakeCertTo(CertOptions{}, "b.example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !KeyMatchesCert(c, key) {
+				t.Error("key doesn't match own certificate")
+			}
+			if KeyMatchesCert(c, other) {
+				t.Error("key matches other certificate")
+			}
+		})
+	}
+}
+
+func TestRequireSAN(t *testing.T) {
+	var root CARoot
+
+	_, err := root.CertTemplate(CertOptions{RequireSAN: true}, "not a host", "a/b")
+	if err == nil {
+		t.Fatal("err is nil")
+	}
+	if have, want := err.Error(), `zcert.CertTemplate: no valid SANs; couldn't use any of: ["not a host" "a/b"]`; have != want {
+		t.Errorf("\nhave: %s\nwant: %s", have, want)
+	}
+
+	tpl, err := root.CertTemplate(CertOptions{RequireSAN: true}, "not a host", "*.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := fmt.Sprint(tpl.DNSNames), "[*.example.com]"; have != want {
+		t.Errorf("\nhave: %s\nwant: %s", have, want)
+	}
+}
+
+func TestCheckDNSName(t *testing.T) {
+	tests := []struct {
+		in, wantErr string
+	}{
+		{"example.com", ""},
+		{"example.com.", ""},
+		{"*.example.com", ""},
+		{"_srv.example-host.com", ""},
+		{"localhost", ""},
+		{"example .com", "contains a space"},
+		{"example\x00.com", "contains control character U+0000"},
+		{"example..com", "empty label"},
+		{".", "empty"},
+		{strings.Repeat("a", 64) + ".com", "longer than 63 characters"},
+		{strings.Repeat("a.", 127) + "com", "longer than 253 characters"},
+		{"foo.*.example.com", "only allowed as the leftmost label"},
+		{"*foo.example.com", "only allowed as the leftmost label"},
+		{"exa$mple.com", "invalid character '$'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			err := checkDNSName(tt.in)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("\nhave: %v\nwant: %s", err, tt.wantErr)
+			}
+		})
+	}
+
+	var root CARoot
+	_, err := root.CertTemplate(CertOptions{}, "example.com", "example .com")
+	if have, want := fmt.Sprint(err), `zcert.CertTemplate: invalid hostname "example .com": contains a space`; have != want {
+		t.Errorf("\nhave: %s\nwant: %s", have, want)
+	}
+}
+
+func TestKeyID(t *testing.T) {
+	tests := []struct {
+		method KeyIDMethod
+		hash   func([]byte) []byte
+	}{
+		{KeyIDSHA1, func(b []byte) []byte { s := sha1.Sum(b); return s[:] }},
+		{KeyIDSHA256, func(b []byte) []byte { s := sha256.Sum256(b); return s[:20] }},
+		{KeyIDSHA384, func(b []byte) []byte { s := sha512.Sum384(b); return s[:20] }},
+		{KeyIDSHA512, func(b []byte) []byte { s := sha512.Sum512(b); return s[:20] }},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.method), func(t *testing.T) {
+			root := newTestRootOpts(t, CARoot{KeyID: tt.method})
+
+			// The key ID is over the subjectPublicKey bits, without the
+			// algorithm identifier.
+			var spki struct {
+				Algorithm        pkix.AlgorithmIdentifier
+				SubjectPublicKey asn1.BitString
+			}
+			_, err := asn1.Unmarshal(root.Certificate().RawSubjectPublicKeyInfo, &spki)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := tt.hash(spki.SubjectPublicKey.Bytes)
+			if have := root.Certificate().SubjectKeyId; !bytes.Equal(have, want) {
+				t.Errorf("\nhave: %x\nwant: %x", have, want)
+			}
+
+			cert, err := root.MakeTLSCert(false, "example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, err := x509.ParseCertificate(cert.Certificate[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(c.AuthorityKeyId, root.Certificate().SubjectKeyId) {
+				t.Errorf("AuthorityKeyId doesn't match\nleaf AKID: %x\nroot SKID: %x",
+					c.AuthorityKeyId, root.Certificate().SubjectKeyId)
+			}
+		})
+	}
+}
+
+type slowStore struct{ truststore.Unix }
+
+func (slowStore) Name() string { return "slow" }
+
+func TestStoreTimeout(t *testing.T) {
+	ca := CARoot{StoreTimeout: 10 * time.Millisecond}
+	err := ca.withTimeout(context.Background(), &slowStore{}, func(context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	})
+	if have, want := fmt.Sprint(err), "slow: timed out after 10ms; skipped"; have != want {
+		t.Errorf("\nhave: %s\nwant: %s", have, want)
+	}
+
+	err = ca.withTimeout(context.Background(), &slowStore{}, func(context.Context) error { return nil })
+	if err != nil {
+		t.Error(err)
+	}
+
+	// f sees the cancellation.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = CARoot{}.withTimeout(ctx, &slowStore{}, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("wrong error: %v", err)
+	}
+}
+
+func TestOnProgress(t *testing.T) {
+	var events []string
+	ca := CARoot{OnProgress: func(store, stage string, err error) {
+		events = append(events, fmt.Sprintf("%s %s %v", store, stage, err))
+	}}
+
+	stores := []truststore.Store{&slowStore{}}
+	res, err := ca.eachStore(context.Background(), stores, StageInstall, func(context.Context, truststore.Store) error {
+		return errors.New("oops")
+	})
+	if err == nil {
+		t.Fatal("err is nil")
+	}
+	if have, want := fmt.Sprint(res), "[{slow oops}]"; have != want {
+		t.Errorf("\nhave: %s\nwant: %s", have, want)
+	}
+	if have, want := strings.Join(events, "\n"), "slow install <nil>\nslow failed oops"; have != want {
+		t.Errorf("\nhave: %s\nwant: %s", have, want)
+	}
+}
+
+func TestCompat(t *testing.T) {
+	root := newTestRootOpts(t, CARoot{Compat: true})
+	if have, want := root.Certificate().PublicKeyAlgorithm, x509.RSA; have != want {
+		t.Errorf("root key: have %s, want %s", have, want)
+	}
+
+	cert, err := root.MakeTLSCert(false, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := c.PublicKeyAlgorithm, x509.ECDSA; have != want {
+		t.Errorf("leaf key: have %s, want %s", have, want)
+	}
+	if err := c.CheckSignatureFrom(root.Certificate()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestKeyAlgorithm(t *testing.T) {
+	tests := []struct {
+		alg     KeyAlgorithm
+		wantAlg x509.PublicKeyAlgorithm
+		wantSig x509.SignatureAlgorithm
+	}{
+		{ECDSAP256, x509.ECDSA, x509.ECDSAWithSHA256},
+		{ECDSAP384, x509.ECDSA, x509.ECDSAWithSHA384},
+		{RSA2048, x509.RSA, x509.SHA256WithRSA},
+		{Ed25519, x509.Ed25519, x509.PureEd25519},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.alg.String(), func(t *testing.T) {
+			root := newTestRootOpts(t, CARoot{KeyAlgorithm: tt.alg})
+			if have := root.Certificate().PublicKeyAlgorithm; have != tt.wantAlg {
+				t.Errorf("root key: have %s, want %s", have, tt.wantAlg)
+			}
+
+			cert, err := root.MakeTLSCert(false, "example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, err := x509.ParseCertificate(cert.Certificate[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if have := c.PublicKeyAlgorithm; have != tt.wantAlg {
+				t.Errorf("leaf key: have %s, want %s", have, tt.wantAlg)
+			}
+			if have := c.SignatureAlgorithm; have != tt.wantSig {
+				t.Errorf("signature: have %s, want %s", have, tt.wantSig)
+			}
+			if err := c.CheckSignatureFrom(root.Certificate()); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+
+	for _, s := range []string{"", "ecdsa", "P384", "rsa", "rsa4096", "Ed25519"} {
+		if _, err := ParseKeyAlgorithm(s); err != nil {
+			t.Errorf("%q: %s", s, err)
+		}
+	}
+	if _, err := ParseKeyAlgorithm("dsa"); err == nil {
+		t.Error("no error for dsa")
+	}
+}
+
+func TestWildcardDepth(t *testing.T) {
+	var root CARoot
+	tpl, err := root.CertTemplate(CertOptions{WildcardDepth: 3}, "*.example.com", "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "[*.example.com example.com *.*.example.com *.*.*.example.com]"
+	if have := fmt.Sprint(tpl.DNSNames); have != want {
+		t.Errorf("\nhave: %s\nwant: %s", have, want)
+	}
+}
+
+func TestWildcard(t *testing.T) {
+	tests := []struct {
+		hosts []string
+		want  string
+	}{
+		{[]string{"example.com"}, "[example.com *.example.com] []"},
+		{[]string{"example.com", "*.example.com"}, "[example.com *.example.com] []"},
+		{[]string{"*.example.com", "example.com"}, "[*.example.com example.com] []"},
+		{[]string{"a.com", "127.0.0.1", "me@example.com"}, "[a.com *.a.com] [127.0.0.1]"},
+	}
+
+	var root CARoot
+	for _, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			tpl, err := root.CertTemplate(CertOptions{Wildcard: true}, tt.hosts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if have := fmt.Sprint(tpl.DNSNames, tpl.IPAddresses); have != tt.want {
+				t.Errorf("\nhave: %s\nwant: %s", have, tt.want)
+			}
+		})
+	}
+}
+
+func TestIDNA(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"example.com", "example.com"},
+		{"Example.COM", "Example.COM"},
+		{"例え.jp", "xn--r8jz45g.jp"},
+		{"例え。jp", "xn--r8jz45g.jp"},
+		{"ｅｘａｍｐｌｅ.com", "example.com"},
+		{"*.bücher.de", "*.xn--bcher-kva.de"},
+		{"MÜNCHEN.de", "xn--mnchen-3ya.de"},
+		{"ليهمابتكلموشعربي؟", "xn--egbpdaj6bu4bxfgehfvwxn"},
+		{"他们为什么不说中文", "xn--ihqwcrb4cv8a8dqg056pqjye"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			have, err := idnaToASCII(tt.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if have != tt.want {
+				t.Errorf("\nhave: %s\nwant: %s", have, tt.want)
+			}
+		})
+	}
+
+	var root CARoot
+	tpl, err := root.CertTemplate(CertOptions{RequireSAN: true}, "例え.jp", "me@例え.jp", "https://例え.jp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := fmt.Sprint(tpl.DNSNames, tpl.EmailAddresses, len(tpl.URIs)), "[xn--r8jz45g.jp] [me@例え.jp] 1"; have != want {
+		t.Errorf("\nhave: %s\nwant: %s", have, want)
+	}
+}
+
+func TestSubject(t *testing.T) {
+	root := newTestRootOpts(t, CARoot{Subject: pkix.Name{Country: []string{"NZ"}}})
+	if have, want := root.Certificate().Subject.String(), "CN=zcert "+userAndHostname()+",OU="+userAndHostname()+",O=zcert development CA,C=NZ"; have != want {
+		t.Errorf("root\nhave: %s\nwant: %s", have, want)
+	}
+
+	tpl, err := root.CertTemplate(CertOptions{
+		Client:  true,
+		Subject: pkix.Name{CommonName: "x", OrganizationalUnit: []string{"a", "b"}},
+	}, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := tpl.Subject.String(), "CN=x,OU=a+OU=b,O=zcert development certificate"; have != want {
+		t.Errorf("leaf\nhave: %s\nwant: %s", have, want)
+	}
+
+	// Organization and OrganizationalUnit from the root are the defaults for
+	// certificates.
+	root.Subject = pkix.Name{Organization: []string{"Acme"}, OrganizationalUnit: []string{"Dev"}, Country: []string{"NZ"}}
+	tpl, err = root.CertTemplate(CertOptions{Subject: pkix.Name{OrganizationalUnit: []string{"Web"}}}, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := tpl.Subject.String(), "OU=Web,O=Acme"; have != want {
+		t.Errorf("leaf with root subject\nhave: %s\nwant: %s", have, want)
+	}
+}
+
+func TestMakeCertPair(t *testing.T) {
+	root := newTestRoot(t)
+	certBuf, keyBuf := new(bytes.Buffer), new(bytes.Buffer)
+	err := root.MakeCertPair(certBuf, keyBuf, CertOptions{}, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if b, rest := pem.Decode(certBuf.Bytes()); b == nil || b.Type != "CERTIFICATE" || len(rest) > 0 {
+		t.Errorf("wrong cert data:\n%s", certBuf)
+	}
+	if b, rest := pem.Decode(keyBuf.Bytes()); b == nil || b.Type != "PRIVATE KEY" || len(rest) > 0 {
+		t.Errorf("wrong key data:\n%s", keyBuf)
+	}
+	if _, err := tls.X509KeyPair(certBuf.Bytes(), keyBuf.Bytes()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMakeCertDER(t *testing.T) {
+	root := newTestRoot(t)
+	certDER, keyDER, err := root.MakeCertDER(CertOptions{}, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := x509.ParsePKCS8PrivateKey(keyDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !KeyMatchesCert(c, k) {
+		t.Error("key doesn't match")
+	}
+}
+
+func TestMakeCertTo(t *testing.T) {
+	root := newTestRoot(t)
+	c, k, err := root.MakeCertTo(CertOptions{}, "example.com", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !KeyMatchesCert(c, k) {
+		t.Error("key doesn't match")
+	}
+	if have, want := fmt.Sprint(c.DNSNames, c.IPAddresses), "[example.com] [127.0.0.1]"; have != want {
+		t.Errorf("\nhave: %s\nwant: %s", have, want)
+	}
+	if err := c.CheckSignatureFrom(root.Certificate()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNow(t *testing.T) {
+	root := newTestRoot(t)
+	root.NotBeforeSkew = -1
+	root.Now = func() time.Time { return time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC) }
+	root.SerialNumber = func() (*big.Int, error) { return big.NewInt(42), nil }
+
+	tpl, err := root.CertTemplate(CertOptions{}, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	have := fmt.Sprint(tpl.SerialNumber, " ", tpl.NotBefore, " ", tpl.NotAfter)
+	want := "42 2020-06-01 00:00:00 +0000 UTC 2021-06-01 00:00:00 +0000 UTC"
+	if have != want {
+		t.Errorf("\nhave: %s\nwant: %s", have, want)
+	}
+
+	// SOURCE_DATE_EPOCH is only used by the zcert command.
+	root.Now = nil
+	old := os.Getenv("SOURCE_DATE_EPOCH")
+	os.Setenv("SOURCE_DATE_EPOCH", "1590969600")
+	defer os.Setenv("SOURCE_DATE_EPOCH", old)
+	tpl, err = root.CertTemplate(CertOptions{}, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(tpl.NotBefore); d < 0 || d > time.Minute {
+		t.Errorf("NotBefore not the current time: %s", tpl.NotBefore)
+	}
+}
+
+func TestRand(t *testing.T) {
+	root := newTestRoot(t)
+
+	var serials []string
+	for i := 0; i < 2; i++ {
+		root.Rand = mrand.New(mrand.NewSource(1))
+		tpl, err := root.CertTemplate(CertOptions{}, "example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		serials = append(serials, tpl.SerialNumber.String())
+	}
+	if serials[0] != serials[1] {
+		t.Errorf("serials differ: %s", serials)
+	}
+
+	c, k, err := root.MakeCertTo(CertOptions{}, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !KeyMatchesCert(c, k) {
+		t.Error("key doesn't match")
+	}
+}
+
+func TestOCSPServer(t *testing.T) {
+	root := newTestRoot(t)
+	root.OCSPServer = []string{"http://localhost:8080/ocsp"}
+
+	tpl, err := root.CertTemplate(CertOptions{}, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := fmt.Sprint(tpl.OCSPServer), "[http://localhost:8080/ocsp]"; have != want {
+		t.Errorf("\nhave: %s\nwant: %s", have, want)
+	}
+}
+
+func TestComment(t *testing.T) {
+	root := newTestRoot(t)
+	buf := new(bytes.Buffer)
+	err := root.MakeCertOpts(buf, CertOptions{Comment: "Acme dev server"}, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsc, err := tls.X509KeyPair(buf.Bytes(), buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := x509.ParseCertificate(tlsc.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var have string
+	for _, e := range c.Extensions {
+		if e.Id.Equal(oidNetscapeComment) {
+			if _, err := asn1.Unmarshal(e.Value, &have); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if want := "Acme dev server"; have != want {
+		t.Errorf("\nhave: %q\nwant: %q", have, want)
+	}
+
+	_, err = root.CertTemplate(CertOptions{Comment: "Café"}, "example.com")
+	if have, want := fmt.Sprint(err), `zcert.CertTemplate: comment "Café": can only contain ASCII characters`; have != want {
+		t.Errorf("\nhave: %s\nwant: %s", have, want)
+	}
+}
+
+type countReader struct{ n int }
+
+func (r *countReader) Read(p []byte) (int, error) {
+	r.n += len(p)
+	return rand.Read(p)
+}
+
+func TestDummySCTs(t *testing.T) {
+	root := newTestRoot(t)
+	now := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	root.Now = func() time.Time { return now }
+	root.Rand = new(countReader)
+
+	var called bool
+	tpl, err := root.CertTemplate(CertOptions{
+		DummySCTs: 2,
+		CustomizeTemplate: func(tpl *x509.Certificate) error {
+			called = true
+			return nil
+		},
+	}, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !called {
+		t.Error("CustomizeTemplate not called")
+	}
+
+	var ext []byte
+	for _, e := range tpl.ExtraExtensions {
+		if e.Id.Equal(oidSCTList) {
+			if _, err := asn1.Unmarshal(e.Value, &ext); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if len(ext) < 2 || int(ext[0])<<8|int(ext[1]) != len(ext)-2 {
+		t.Fatalf("wrong list length: %x", ext)
+	}
+
+	var n int
+	for l := ext[2:]; len(l) > 0; n++ {
+		size := int(l[0])<<8 | int(l[1])
+		if size > len(l)-2 || l[2] != 0 {
+			t.Fatalf("malformed SCT: %x", l)
+		}
+		// Version, log ID, and timestamp in milliseconds.
+		if ts := int64(binary.BigEndian.Uint64(l[3+32:])); ts != now.UnixNano()/int64(time.Millisecond) {
+			t.Errorf("timestamp %d", ts)
+		}
+		l = l[2+size:]
+	}
+	if n != 2 {
+		t.Errorf("%d SCTs", n)
+	}
+	if root.Rand.(*countReader).n == 0 {
+		t.Error("Rand not used")
+	}
+}
+
+func TestLocalhostTLSConfig(t *testing.T) {
+	test := func(t *testing.T) {
+		tlsc, err := LocalhostTLSConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(tlsc.Certificates) != 1 {
+			t.Fatalf("len(Certificates) = %d", len(tlsc.Certificates))
+		}
+		c, err := x509.ParseCertificate(tlsc.Certificates[0].Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have, want := fmt.Sprint(c.DNSNames, c.IPAddresses), "[localhost] [127.0.0.1 ::1]"; have != want {
+			t.Errorf("\nhave: %s\nwant: %s", have, want)
+		}
+	}
+
+	t.Run("root", func(t *testing.T) {
+		newTestRoot(t)
+		test(t)
+	})
+	t.Run("ephemeral", func(t *testing.T) {
+		newTestRoot(t)
+		os.Setenv("CAROOT", "/dev/null/zcert") // Can't be created.
+		test(t)
+	})
+}
+
+// Run with -race to check that GetCertificate is safe for concurrent use.
+func TestTLSConfigConcurrent(t *testing.T) {
+	root := newTestRoot(t)
+	root.Quiet = true
+	tlsc := root.TLSConfig()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := tlsc.GetCertificate(&tls.ClientHelloInfo{ServerName: fmt.Sprintf("host%d.example.com", i%5)})
+			if err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// Should be cached now.
+	for i := 0; i < 5; i++ {
+		hello := &tls.ClientHelloInfo{ServerName: fmt.Sprintf("host%d.example.com", i)}
+		c1, _ := tlsc.GetCertificate(hello)
+		c2, _ := tlsc.GetCertificate(hello)
+		if c1 != c2 {
+			t.Errorf("%s: not cached", hello.ServerName)
+		}
+	}
+}
+
+func TestWarmCache(t *testing.T) {
+	root := newTestRoot(t)
+	r := new(countReader)
+	root.Rand = r
+	tlsc := root.TLSConfig()
+	err := WarmCache(tlsc, "a.example.com", "b.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.n == 0 {
+		t.Fatal("no certificates created")
+	}
+
+	// Every new certificate reads from Rand, so nothing should be read now.
+	warm := r.n
+	for _, h := range []string{"a.example.com", "b.example.com"} {
+		_, err := tlsc.GetCertificate(&tls.ClientHelloInfo{ServerName: h})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if r.n != warm {
+		t.Error("certificates not cached by WarmCache")
+	}
+
+	err = WarmCache(&tls.Config{}, "a.example.com")
+	if err == nil {
+		t.Error("no error for tls.Config without GetCertificate")
+	}
+}
+
+func TestCertCache(t *testing.T) {
+	c := newCertCache(3)
+	certs := make([]*tls.Certificate, 5)
+	for i := range certs {
+		certs[i] = new(tls.Certificate)
+	}
+
+	c.add("a", certs[0])
+	c.add("b", certs[1])
+	c.add("c", certs[2])
+	c.get("a") // Now b is the least recently used.
+	c.add("d", certs[3])
+	if c.len() != 3 {
+		t.Errorf("len is %d", c.len())
+	}
+	if c.get("b") != nil {
+		t.Error("b not evicted")
+	}
+	if c.get("a") != certs[0] || c.get("c") != certs[2] || c.get("d") != certs[3] {
+		t.Error("wrong certificates")
+	}
+	if have := c.add("a", certs[4]); have != certs[0] {
+		t.Error("add replaced existing certificate")
+	}
+
+	t.Run("TLSConfig", func(t *testing.T) {
+		root := newTestRoot(t)
+		tlsc := root.TLSConfigOpts(TLSConfigOptions{CacheSize: 2})
+		var first *tls.Certificate
+		for i := 0; i < 5; i++ {
+			c, err := tlsc.GetCertificate(&tls.ClientHelloInfo{ServerName: fmt.Sprintf("host%d.example.com", i)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if i == 0 {
+				first = c
+			}
+		}
+		c, err := tlsc.GetCertificate(&tls.ClientHelloInfo{ServerName: "host0.example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c == first {
+			t.Error("host0 not evicted")
+		}
+	})
+}
+
+func TestIntermediate(t *testing.T) {
+	root := newTestRoot(t)
+	_, err := root.CreateIntermediate()
+	if err == nil {
+		t.Fatal("no error for root with MaxPathLen 0")
+	}
+
+	root = newTestRootOpts(t, CARoot{MaxPathLen: 1})
+	if have := root.Certificate().MaxPathLen; have != 1 {
+		t.Fatalf("root MaxPathLen %d", have)
+	}
+	ica, err := root.CreateIntermediate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ic := ica.Certificate()
+	if !ic.IsCA || ic.MaxPathLen != 0 || !ic.MaxPathLenZero {
+		t.Errorf("IsCA: %t; MaxPathLen: %d; MaxPathLenZero: %t", ic.IsCA, ic.MaxPathLen, ic.MaxPathLenZero)
+	}
+	if _, err := ica.CreateIntermediate(); err == nil {
+		t.Error("no error for intermediate with MaxPathLen 0")
+	}
+	if !ica.RootCertificate().Equal(root.Certificate()) {
+		t.Error("RootCertificate() isn't the root")
+	}
+
+	// The p12 has the full chain.
+	p12 := new(bytes.Buffer)
+	err = ica.MakeP12(p12, "sekrit", false, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs := p12Certs(t, p12.Bytes())
+	if len(certs) != 3 || !certs[1].Equal(ic) || !certs[2].Equal(root.Certificate()) {
+		t.Errorf("wrong chain in p12: %d certificates", len(certs))
+	}
+
+	buf := new(bytes.Buffer)
+	err = ica.MakeCert(buf, false, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := tls.X509KeyPair(buf.Bytes(), buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cert.Certificate) != 2 {
+		t.Fatalf("%d certificates in output", len(cert.Certificate))
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	inter, err := x509.ParseCertificate(cert.Certificate[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roots, inters := x509.NewCertPool(), x509.NewCertPool()
+	roots.AddCert(root.Certificate())
+	inters.AddCert(inter)
+	chains, err := leaf.Verify(x509.VerifyOptions{Roots: roots, Intermediates: inters, DNSName: "example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chains[0]) != 3 {
+		t.Errorf("chain length %d", len(chains[0]))
+	}
+}
+
+func TestIncludeRoot(t *testing.T) {
+	root := newTestRoot(t)
+	buf := new(bytes.Buffer)
+	err := root.MakeCertOpts(buf, CertOptions{IncludeRoot: true}, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := tls.X509KeyPair(buf.Bytes(), buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cert.Certificate) != 2 {
+		t.Fatalf("%d certificates in output", len(cert.Certificate))
+	}
+	if !bytes.Equal(cert.Certificate[1], root.Certificate().Raw) {
+		t.Error("second certificate isn't the root")
+	}
+}
+
+func TestSignCSR(t *testing.T) {
+	root := newTestRoot(t)
+
+	var (
+		clientAuth  = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 2}
+		codeSigning = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 3}
+	)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newCSR := func(oids ...asn1.ObjectIdentifier) *x509.CertificateRequest {
+		tpl := &x509.CertificateRequest{
+			Subject:  pkix.Name{CommonName: "cn.example.com", Organization: []string{"CSR"}},
+			DNSNames: []string{"a.example.com", "b.example.com"},
+		}
+		if len(oids) > 0 {
+			v, err := asn1.Marshal(oids)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tpl.ExtraExtensions = []pkix.Extension{{Id: oidExtKeyUsage, Value: v}}
+		}
+		der, err := x509.CreateCertificateRequest(rand.Reader, tpl, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		csr, err := x509.ParseCertificateRequest(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return csr
+	}
+	parse := func(t *testing.T, data []byte) *x509.Certificate {
+		t.Helper()
+		b, _ := pem.Decode(data)
+		c, err := x509.ParseCertificate(b.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		csr     *x509.CertificateRequest
+		opts    SignCSROptions
+		wantDNS string
+		wantEKU string
+		wantErr string
+	}{
+		{newCSR(clientAuth), SignCSROptions{},
+			"[cn.example.com]", "[1]", ""},
+		{newCSR(clientAuth), SignCSROptions{CopyExtensions: true},
+			"[a.example.com b.example.com]", "[2]", ""},
+		{newCSR(), SignCSROptions{CopyExtensions: true},
+			"[a.example.com b.example.com]", "[1]", ""},
+		{newCSR(codeSigning), SignCSROptions{CopyExtensions: true},
+			"", "", "codeSigning not allowed"},
+		{newCSR(codeSigning), SignCSROptions{CopyExtensions: true, AllowedEKU: []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning}},
+			"[a.example.com b.example.com]", "[3]", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			data, err := root.SignCSR(tt.csr, tt.opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("wrong error: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c := parse(t, data)
+			if have := fmt.Sprint(c.DNSNames); have != tt.wantDNS {
+				t.Errorf("DNSNames\nhave: %s\nwant: %s", have, tt.wantDNS)
+			}
+			if have := fmt.Sprintf("%d", c.ExtKeyUsage); have != tt.wantEKU {
+				t.Errorf("ExtKeyUsage\nhave: %s\nwant: %s", have, tt.wantEKU)
+			}
+			if have, want := c.Subject.String(), "CN=cn.example.com,OU="+userAndHostname()+",O=CSR"; have != want {
+				t.Errorf("Subject\nhave: %s\nwant: %s", have, want)
+			}
+			if !KeyMatchesCert(c, key) {
+				t.Error("key doesn't match")
+			}
+			if err := c.CheckSignatureFrom(root.Certificate()); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestP12KDF(t *testing.T) {
+	// Generated with "openssl kdf [..] PKCS12KDF".
+	tests := []struct {
+		pass, salt string
+		id         byte
+		iter, size int
+		want       string
+	}{
+		{"sekrit", "\x01\x02\x03\x04\x05\x06\x07\x08", 1, 2048, 24, "4f197a10177be12b893eb7bdff4ee426fc1e64de9bf1e339"},
+		{"smeg", "\x0a\x58\xcf\x64\x53\x0d\x82\x3f", 3, 1, 20, "c5cb50aa5f5ce3059f6e6c267c9c38474003303a"},
+	}
+	for _, tt := range tests {
+		have := fmt.Sprintf("%x", p12KDF([]byte(tt.pass), []byte(tt.salt), tt.id, tt.iter, tt.size))
+		if have != tt.want {
+			t.Errorf("%s\nhave: %s\nwant: %s", tt.pass, have, tt.want)
+		}
+	}
+}
+
+// p12Certs gets all certificates from a PKCS#12 file from encodeP12().
+func p12Certs(t *testing.T, data []byte) []*x509.Certificate {
+	t.Helper()
+	unmarshal := func(b []byte, v interface{}) {
+		t.Helper()
+		if _, err := asn1.Unmarshal(b, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var (
+		pfx         p12PFX
+		authSafeDER []byte
+		authSafe    []p12ContentInfo
+		bagsDER     []byte
+		bags        []p12SafeBag
+	)
+	unmarshal(data, &pfx)
+	unmarshal(pfx.AuthSafe.Content.Bytes, &authSafeDER)
+	unmarshal(authSafeDER, &authSafe)
+	unmarshal(authSafe[0].Content.Bytes, &bagsDER)
+	unmarshal(bagsDER, &bags)
+
+	certs := make([]*x509.Certificate, 0, len(bags))
+	for _, b := range bags {
+		var cb p12CertBag
+		unmarshal(b.Value.Bytes, &cb)
+		c, err := x509.ParseCertificate(cb.Data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		certs = append(certs, c)
+	}
+	return certs
+}
+
+func TestMakeP12(t *testing.T) {
+	root := newTestRoot(t)
+	buf := new(bytes.Buffer)
+	err := root.MakeP12(buf, "sekrit", false, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pfx p12PFX
+	if _, err := asn1.Unmarshal(buf.Bytes(), &pfx); err != nil {
+		t.Fatal(err)
+	}
+	var authSafeDER []byte
+	if _, err := asn1.Unmarshal(pfx.AuthSafe.Content.Bytes, &authSafeDER); err != nil {
+		t.Fatal(err)
+	}
+
+	pass := append(bmpString("sekrit"), 0, 0)
+	mac := hmac.New(sha1.New, p12KDF(pass, pfx.MacData.MacSalt, 3, pfx.MacData.Iterations, 20))
+	mac.Write(authSafeDER)
+	if !hmac.Equal(mac.Sum(nil), pfx.MacData.Mac.Digest) {
+		t.Fatal("MAC doesn't verify")
+	}
+
+	var authSafe []p12ContentInfo
+	if _, err := asn1.Unmarshal(authSafeDER, &authSafe); err != nil {
+		t.Fatal(err)
+	}
+	if len(authSafe) != 2 {
+		t.Fatalf("len(authSafe) = %d", len(authSafe))
+	}
+	bags := func(ci p12ContentInfo) []p12SafeBag {
+		var der []byte
+		if _, err := asn1.Unmarshal(ci.Content.Bytes, &der); err != nil {
+			t.Fatal(err)
+		}
+		var b []p12SafeBag
+		if _, err := asn1.Unmarshal(der, &b); err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	var certs []*x509.Certificate
+	for _, b := range bags(authSafe[0]) {
+		var cb p12CertBag
+		if _, err := asn1.Unmarshal(b.Value.Bytes, &cb); err != nil {
+			t.Fatal(err)
+		}
+		c, err := x509.ParseCertificate(cb.Data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		certs = append(certs, c)
+	}
+	if len(certs) != 2 || !certs[1].Equal(root.Certificate()) {
+		t.Fatalf("wrong certificates: %d", len(certs))
+	}
+
+	keyBags := bags(authSafe[1])
+	var ek p12EncryptedKey
+	if _, err := asn1.Unmarshal(keyBags[0].Value.Bytes, &ek); err != nil {
+		t.Fatal(err)
+	}
+	var params p12PBEParams
+	if _, err := asn1.Unmarshal(ek.Algorithm.Parameters.FullBytes, &params); err != nil {
+		t.Fatal(err)
+	}
+	block, err := des.NewTripleDESCipher(p12KDF(pass, params.Salt, 1, params.Iterations, 24))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec := make([]byte, len(ek.Data))
+	cipher.NewCBCDecrypter(block, p12KDF(pass, params.Salt, 2, params.Iterations, 8)).CryptBlocks(dec, ek.Data)
+	key, err := x509.ParsePKCS8PrivateKey(dec[:len(dec)-int(dec[len(dec)-1])])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !KeyMatchesCert(certs[0], key) {
+		t.Error("key doesn't match certificate")
+	}
+
+	// No hosts; the friendly name is the first SAN.
+	err = root.MakeP12Opts(new(bytes.Buffer), "sekrit", CertOptions{Localhost: true})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+// BenchmarkMakeCert shows that creating certificates doesn't accumulate
+// memory: it issues 10,000 certificates to a discard writer per iteration, and
+// fails if the live heap after the last one is more than 1M larger than after
+// the first 1,000. The growth is reported as heap-growth-B.
+func BenchmarkMakeCert(b *testing.B) {
+	tmp, err := ioutil.TempDir("", "zcert-")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	root := CARoot{Dir: tmp, Quiet: true}
+	err = root.Create()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	heap := func() uint64 {
+		var m runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&m)
+		return m.HeapAlloc
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		var start uint64
+		for i := 0; i < 10_000; i++ {
+			if i == 1_000 {
+				start = heap()
+			}
+			err := root.MakeCert(ioutil.Discard, false, "example.com")
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+
+		growth := int64(heap()) - int64(start)
+		b.ReportMetric(float64(growth), "heap-growth-B")
+		if growth > 1<<20 {
+			b.Fatalf("heap grew by %d bytes while issuing 9,000 certificates", growth)
+		}
+	}
+}